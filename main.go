@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	o2r "github.com/JAORMX/oslopolicy2rego/parser"
 )
@@ -17,6 +18,26 @@ func main() {
 		"package name to use for the rego policy.")
 	inputFile := flag.String("input", "", "Path to input oslo.policy file.")
 	outputFile := flag.String("output", "", "Path to input oslo.policy file.")
+	sortRules := flag.Bool("sort-rules", false,
+		"Emit the rules sorted by name instead of in the input's order.")
+	httpChecks := flag.String("http-checks", "send",
+		"How to convert http checks: send, error or data.")
+	strictRoleMatching := flag.Bool("strict-role-matching", false,
+		"Compare roles case-sensitively instead of ignoring the case.")
+	strictReferences := flag.Bool("strict-references", false,
+		"Fail if a rule references an undefined rule or if there's a reference cycle.")
+	regoVersion := flag.String("rego-version", "v0",
+		"Version of the rego syntax to generate: v0 or v1.")
+	inputCredentials := flag.String("input-credentials", "credentials",
+		"Path of the credentials in the input document.")
+	inputAction := flag.String("input-action", "rule",
+		"Path of the name of the rule being checked in the input document.")
+	inputTarget := flag.String("input-target", "target",
+		"Path of the target in the input document.")
+	partialOutput := flag.Bool("partial-output", false,
+		"Write the policy even if some rules fail, with the failed rules never holding.")
+	suppressWarnings := flag.String("suppress-warnings", "",
+		"Comma separated list of the codes of the warnings not to print.")
 
 	flag.Parse()
 
@@ -24,6 +45,25 @@ func main() {
 		panic("Must specify an input file.")
 	}
 
+	httpCheckModes := map[string]o2r.HTTPCheckMode{
+		"send":  o2r.HTTPCheckSend,
+		"error": o2r.HTTPCheckError,
+		"data":  o2r.HTTPCheckData,
+	}
+	httpCheckMode, ok := httpCheckModes[*httpChecks]
+	if !ok {
+		panic(fmt.Sprintf("Invalid value for http-checks: %s", *httpChecks))
+	}
+
+	regoVersions := map[string]o2r.RegoVersion{
+		"v0": o2r.RegoV0,
+		"v1": o2r.RegoV1,
+	}
+	regoVersionValue, ok := regoVersions[*regoVersion]
+	if !ok {
+		panic(fmt.Sprintf("Invalid value for rego-version: %s", *regoVersion))
+	}
+
 	inputStream, err := ioutil.ReadFile(*inputFile)
 	if err != nil {
 		panic(err)
@@ -39,9 +79,42 @@ func main() {
 			panic(err)
 		}
 	}
-	outputString, err := o2r.OsloPolicy2Rego(*packageName, inputString)
+	opts := o2r.Options{
+		SortRules:          *sortRules,
+		HTTPChecks:         httpCheckMode,
+		StrictRoleMatching: *strictRoleMatching,
+		StrictReferences:   *strictReferences,
+		RegoVersion:        regoVersionValue,
+		Input: o2r.InputLayout{
+			Credentials: *inputCredentials,
+			Action:      *inputAction,
+			Target:      *inputTarget,
+		},
+		PartialOutput: *partialOutput,
+		Warnings: func(warning o2r.Warning) {
+			fmt.Fprintf(os.Stderr, "%v\n", warning)
+		},
+	}
+	if *suppressWarnings != "" {
+		warningCodes := make(map[o2r.WarningCode]bool)
+		for _, code := range o2r.WarningCodes() {
+			warningCodes[code] = true
+		}
+		for _, value := range strings.Split(*suppressWarnings, ",") {
+			code := o2r.WarningCode(strings.TrimSpace(value))
+			if !warningCodes[code] {
+				panic(fmt.Sprintf("Invalid value for suppress-warnings: %s", value))
+			}
+			opts.SuppressWarnings = append(opts.SuppressWarnings, code)
+		}
+	}
+	outputString, err := o2r.OsloPolicy2RegoWithOptions(*packageName, inputString, opts)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
+	// With partial-output, the policy is written even if some rules failed
 	fmt.Fprint(outputStream, outputString)
+	if err != nil {
+		os.Exit(1)
+	}
 }
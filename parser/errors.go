@@ -0,0 +1,165 @@
+package oslopolicy2rego
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrorKind tells what kind of problem a PolicyError reports.
+type ErrorKind int
+
+const (
+	// ErrorInvalidDocument means the input isn't valid YAML or JSON.
+	ErrorInvalidDocument ErrorKind = iota
+	// ErrorInvalidKey means a key of the policy isn't a string.
+	ErrorInvalidKey
+	// ErrorInvalidValue means the value of a rule isn't an expression nor
+	// a list of checks, or a check of the policy tree is of an unknown
+	// type.
+	ErrorInvalidValue
+	// ErrorUnexpectedToken means an expression has a word, an operator or
+	// a parenthesis where it doesn't belong.
+	ErrorUnexpectedToken
+	// ErrorUnexpectedEnd means an expression finishes where an operand
+	// was expected.
+	ErrorUnexpectedEnd
+	// ErrorUnclosedParenthesis means an opening parenthesis isn't closed.
+	ErrorUnclosedParenthesis
+	// ErrorUnmatchedParenthesis means a closing parenthesis wasn't
+	// opened.
+	ErrorUnmatchedParenthesis
+	// ErrorMissingOperand means a check lacks the value on one side of
+	// its colon.
+	ErrorMissingOperand
+	// ErrorUnsupportedFormat means a value uses a python format that
+	// can't be converted, e.g. "%(name)d".
+	ErrorUnsupportedFormat
+	// ErrorHTTPCheckNotAllowed means the policy has an HTTP check, and the
+	// HTTPChecks option is HTTPCheckError.
+	ErrorHTTPCheckNotAllowed
+	// ErrorInvalidReference means a "rule:" check refers to an undefined
+	// rule or is part of a cycle, and the StrictReferences option is set.
+	ErrorInvalidReference
+	// ErrorInvalidOption means the package name or an option given for
+	// the conversion is invalid.
+	ErrorInvalidOption
+)
+
+// PolicyError reports a policy that can't be parsed or converted. It can be
+// told apart from other errors with errors.As.
+type PolicyError struct {
+	Kind ErrorKind
+	// Key is the key of the rule that has the problem, if it's about a
+	// rule.
+	Key string
+	// Expression is the expression that has the problem, as it was
+	// written, if there's one.
+	Expression string
+	// Column is the position (counting characters, starting at 1) of the
+	// problem in the Expression, or 0 if it isn't known.
+	Column int
+	// Message describes the problem.
+	Message string
+	// Err is the error that caused this one, if any.
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	message := e.Message
+	if e.Column > 0 {
+		// The whitespace of the expression is shown as spaces, so that
+		// the caret stays aligned even if it spans several lines.
+		expression := strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return ' '
+			}
+			return r
+		}, e.Expression)
+		message = fmt.Sprintf("%s at column %d:\n    %s\n    %s^",
+			e.Message, e.Column, expression, strings.Repeat(" ", e.Column-1))
+	}
+	if e.Key != "" {
+		return fmt.Sprintf("Error in key %s: \"%v\"", e.Key, message)
+	}
+	return message
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyErrors holds every problem found in a policy, in the order they were
+// found. The PolicyError of each of them can be found with errors.As.
+type PolicyErrors []*PolicyError
+
+func (e PolicyErrors) Error() string {
+	var errorMessages []string
+	for _, policyError := range e {
+		errorMessages = append(errorMessages, policyError.Error())
+	}
+	return strings.Join(errorMessages, "\n")
+}
+
+func (e PolicyErrors) Unwrap() []error {
+	var unwrapped []error
+	for _, policyError := range e {
+		unwrapped = append(unwrapped, policyError)
+	}
+	return unwrapped
+}
+
+// asPolicyErrors returns the problems that the given error reports.
+func asPolicyErrors(err error) PolicyErrors {
+	switch typedError := err.(type) {
+	case nil:
+		return nil
+	case PolicyErrors:
+		return typedError
+	case *PolicyError:
+		return PolicyErrors{typedError}
+	}
+	return PolicyErrors{{Kind: ErrorInvalidValue, Message: err.Error(), Err: err}}
+}
+
+// errorOrNil returns the given errors as an error, or nil if there are none.
+func errorOrNil(errs PolicyErrors) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// inKey returns the problems that the given error reports as problems of the
+// rule with the given key.
+func inKey(key string, err error) PolicyErrors {
+	var keyErrors PolicyErrors
+	for _, policyError := range asPolicyErrors(err) {
+		keyError := *policyError
+		keyError.Key = key
+		keyErrors = append(keyErrors, &keyError)
+	}
+	return keyErrors
+}
+
+// inExpression points the given problems, which are about single checks, at
+// the checks in the given expression that the rule was parsed from. The
+// problems that already point at a column, and the ones about checks that
+// aren't in the expression, e.g. because the policy was modified, are left as
+// they are.
+func inExpression(expression string, errs PolicyErrors) PolicyErrors {
+	tokens := tokenize(expression)
+	for _, policyError := range errs {
+		if policyError.Column > 0 || policyError.Expression == "" {
+			continue
+		}
+		for _, checkToken := range tokens {
+			if checkToken.kind == tokenCheck && checkToken.value == policyError.Expression {
+				policyError.Expression = expression
+				policyError.Column = checkToken.column
+				break
+			}
+		}
+	}
+	return errs
+}
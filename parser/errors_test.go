@@ -0,0 +1,154 @@
+package oslopolicy2rego
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPolicyErrorDescribesTheProblem(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		opts        Options
+		want        PolicyError
+	}{
+		{"Invalid document", `{"a": "@"`, Options{},
+			PolicyError{Kind: ErrorInvalidDocument}},
+		{"Invalid key", `{1: "@"}`, Options{},
+			PolicyError{Kind: ErrorInvalidKey}},
+		{"Invalid value", `{"a": 1}`, Options{},
+			PolicyError{Kind: ErrorInvalidValue, Key: "a"}},
+		{"Unexpected token", `{"a": "rule:b rule:c"}`, Options{},
+			PolicyError{Kind: ErrorUnexpectedToken, Key: "a", Expression: "rule:b rule:c", Column: 8}},
+		{"Unexpected token in a list", `{"a": [["b"]]}`, Options{},
+			PolicyError{Kind: ErrorUnexpectedToken, Key: "a", Expression: "b"}},
+		{"Unexpected end", `{"a": "rule:b and"}`, Options{},
+			PolicyError{Kind: ErrorUnexpectedEnd, Key: "a", Expression: "rule:b and", Column: 11}},
+		{"Unclosed parenthesis", `{"a": "(rule:b"}`, Options{},
+			PolicyError{Kind: ErrorUnclosedParenthesis, Key: "a", Expression: "(rule:b", Column: 1}},
+		{"Unmatched parenthesis", `{"a": "rule:b)"}`, Options{},
+			PolicyError{Kind: ErrorUnmatchedParenthesis, Key: "a", Expression: "rule:b)", Column: 7}},
+		{"Missing operand", `{"a": "rule:b or c:"}`, Options{},
+			PolicyError{Kind: ErrorMissingOperand, Key: "a", Expression: "rule:b or c:", Column: 11}},
+		{"Unsupported format", `{"a": "rule:b or c:%(d)i"}`, Options{},
+			PolicyError{Kind: ErrorUnsupportedFormat, Key: "a", Expression: "rule:b or c:%(d)i", Column: 13}},
+		{"Unsupported format in a quoted match", `{"a": "c:'x-%(d)i'"}`, Options{},
+			PolicyError{Kind: ErrorUnsupportedFormat, Key: "a", Expression: "c:'x-%(d)i'", Column: 6}},
+		{"Unsupported format in a URL", `{"a": "http://x/%d"}`, Options{},
+			PolicyError{Kind: ErrorUnsupportedFormat, Key: "a", Expression: "http://x/%d", Column: 10}},
+		{"Unsupported format in a list", `{"a": [["c:%(d)i"]]}`, Options{},
+			PolicyError{Kind: ErrorUnsupportedFormat, Key: "a", Expression: "c:%(d)i", Column: 3}},
+		{"HTTP check not allowed", `{"a": "role:b or http://x"}`, Options{HTTPChecks: HTTPCheckError},
+			PolicyError{Kind: ErrorHTTPCheckNotAllowed, Key: "a", Expression: "role:b or http://x", Column: 11}},
+		{"HTTP check not allowed in a list", `{"a": [["http://x"]]}`, Options{HTTPChecks: HTTPCheckError},
+			PolicyError{Kind: ErrorHTTPCheckNotAllowed, Key: "a", Expression: "http://x"}},
+		{"Invalid reference", `{"a": "rule:b"}`, Options{StrictReferences: true},
+			PolicyError{Kind: ErrorInvalidReference, Key: "a"}},
+		{"Invalid option", `{"a": "@"}`, Options{Input: InputLayout{Target: "x-y"}},
+			PolicyError{Kind: ErrorInvalidOption}},
+	}
+	for _, c := range cases {
+		_, err := OsloPolicy2RegoWithOptions("openstack.policy", c.input, c.opts)
+		var policyError *PolicyError
+		if !errors.As(fmt.Errorf("wrapped: %w", err), &policyError) {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" didn't fail with a PolicyError: %v",
+				c.description, err)
+			continue
+		}
+		if policyError.Kind != c.want.Kind || policyError.Key != c.want.Key ||
+			policyError.Expression != c.want.Expression || policyError.Column != c.want.Column {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" failed with kind %d, key %q, "+
+				"expression %q and column %d instead of kind %d, key %q, expression %q and column %d",
+				c.description, policyError.Kind, policyError.Key, policyError.Expression, policyError.Column,
+				c.want.Kind, c.want.Key, c.want.Expression, c.want.Column)
+		}
+	}
+}
+
+func TestPolicyErrorMessage(t *testing.T) {
+	err := &PolicyError{Kind: ErrorUnexpectedToken, Key: "a", Expression: "rule:b\trule:c", Column: 8,
+		Message: `Expected "and" or "or" instead of "rule:c"`}
+	want := `Error in key a: "Expected "and" or "or" instead of "rule:c" at column 8:
+    rule:b rule:c
+           ^"`
+	if err.Error() != want {
+		t.Errorf("PolicyError.Error() didn't match:\n%s\nInstead got:\n%s", want, err.Error())
+	}
+}
+
+func TestPolicyErrorUnwrapsTheCause(t *testing.T) {
+	_, err := ParsePolicy(`{"a": "@"`)
+	var policyError *PolicyError
+	if !errors.As(err, &policyError) {
+		t.Fatalf("ParsePolicy() didn't fail with a PolicyError: %v", err)
+	}
+	if policyError.Unwrap() == nil || policyError.Error() != policyError.Unwrap().Error() {
+		t.Errorf("PolicyError.Unwrap() didn't give the YAML error, got: %v", policyError.Unwrap())
+	}
+}
+
+func TestOsloPolicy2RegoReportsAllErrors(t *testing.T) {
+	input := `
+{
+	"a": "rule:b and c: or (rule:d",
+	"b": "role:admin",
+	"c": [["x:", "@"], ["y"]],
+	"d": "e:%(f)d",
+	"g": "not rule:h"
+}`
+	want := []PolicyError{
+		{Kind: ErrorMissingOperand, Key: "a", Column: 12},
+		{Kind: ErrorUnclosedParenthesis, Key: "a", Column: 18},
+		{Kind: ErrorMissingOperand, Key: "c"},
+		{Kind: ErrorUnexpectedToken, Key: "c"},
+		{Kind: ErrorUnsupportedFormat, Key: "d", Column: 3},
+		{Kind: ErrorInvalidReference, Key: "g"},
+	}
+	got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, Options{StrictReferences: true})
+	if got != "" {
+		t.Errorf("OsloPolicy2RegoWithOptions() shouldn't have returned a policy, got:\n%s", got)
+	}
+	policyErrors, ok := err.(PolicyErrors)
+	if !ok {
+		t.Fatalf("OsloPolicy2RegoWithOptions() didn't fail with PolicyErrors: %v", err)
+	}
+	if len(policyErrors) != len(want) {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed with %d errors instead of %d:\n%v",
+			len(policyErrors), len(want), err)
+	}
+	for index, policyError := range policyErrors {
+		if policyError.Kind != want[index].Kind || policyError.Key != want[index].Key ||
+			policyError.Column != want[index].Column {
+			t.Errorf("OsloPolicy2RegoWithOptions() error %d has kind %d, key %q and column %d "+
+				"instead of kind %d, key %q and column %d: %v", index, policyError.Kind, policyError.Key,
+				policyError.Column, want[index].Kind, want[index].Key, want[index].Column, policyError)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoPartialOutput(t *testing.T) {
+	input := `
+{
+	"admin": "role:admin",
+	"secrets:get": "rule:admin or project_id:",
+	"secrets:list": "rule:admin"
+}`
+	got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, Options{PartialOutput: true})
+	if err == nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() should have failed, instead got:\n%s", got)
+	}
+	want := []string{`admin {
+    lower(credentials.roles[_]) = "admin"
+}`, `secrets_get {
+    false
+}`, `secrets_list {
+    admin
+}`, `actions = {"secrets:get", "secrets:list"}`}
+	for _, rule := range want {
+		if !strings.Contains(got, rule) {
+			t.Errorf("OsloPolicy2RegoWithOptions() didn't contain:\n%s\nGot:\n%s", rule, got)
+		}
+	}
+}
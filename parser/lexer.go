@@ -0,0 +1,138 @@
+package oslopolicy2rego
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenLeftParenthesis tokenKind = iota
+	tokenRightParenthesis
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenCheck
+	tokenEnd
+)
+
+// token is a lexical unit of an oslo.policy expression. The column is the
+// position (counting characters, starting at 1) where it starts in the
+// expression.
+type token struct {
+	kind   tokenKind
+	value  string
+	column int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of expression"
+	}
+	return "\"" + t.value + "\""
+}
+
+// tokenize splits the given oslo.policy expression into tokens the same way
+// oslo.policy does: words are delimited by whitespace, the parentheses at
+// the start and the end of a word are tokens of their own, and "and", "or"
+// and "not" are keywords regardless of their case. Every other word is a
+// check. The returned list always finishes with a tokenEnd.
+//
+// A single or double quote at the start of a check, or right after its
+// colon, opens a quoted literal that runs until the matching quote. Quoted
+// literals keep their whitespace and parentheses, e.g. "'my role':%(name)s"
+// is a single check. A quote that isn't closed is an ordinary character.
+func tokenize(expression string) []token {
+	var tokens []token
+	runes := []rune(expression)
+
+	for index := 0; index < len(runes); {
+		if unicode.IsSpace(runes[index]) {
+			index++
+			continue
+		}
+		start := index
+		// The end of the last quoted literal of the word; the parentheses
+		// before it are part of the check.
+		quotedEnd := start
+		for index < len(runes) && !unicode.IsSpace(runes[index]) {
+			if quoteOpensLiteral(runes[start : index+1]) {
+				quote := runes[index]
+				closing := index + 1
+				for closing < len(runes) && runes[closing] != quote {
+					closing++
+				}
+				// Like in oslo.policy, a quote that isn't closed is an
+				// ordinary character, e.g. "name:'s-team".
+				if closing < len(runes) {
+					index = closing
+					quotedEnd = closing + 1
+				}
+			}
+			index++
+		}
+		tokens = append(tokens, tokenizeWord(runes[start:index], start+1, quotedEnd-start)...)
+	}
+
+	return append(tokens, token{kind: tokenEnd, column: len(runes) + 1})
+}
+
+// quoteOpensLiteral tells if the last character of the given word prefix is
+// a quote that opens a quoted literal, which is the case at the start of the
+// check (after any opening parentheses) or right after a colon.
+func quoteOpensLiteral(prefix []rune) bool {
+	last := prefix[len(prefix)-1]
+	if last != '\'' && last != '"' {
+		return false
+	}
+	if len(prefix) == 1 {
+		return true
+	}
+	previous := prefix[len(prefix)-2]
+	if previous == ':' {
+		return true
+	}
+	for _, r := range prefix[:len(prefix)-1] {
+		if r != '(' {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeWord splits a word that starts at the given column into tokens.
+// The closing parentheses found before quotedEnd belong to a quoted literal,
+// so they aren't split off the check.
+func tokenizeWord(word []rune, column int, quotedEnd int) []token {
+	var tokens []token
+
+	start := 0
+	for start < len(word) && word[start] == '(' {
+		tokens = append(tokens, token{kind: tokenLeftParenthesis, value: "(", column: column + start})
+		start++
+	}
+	end := len(word)
+	for end > start && end > quotedEnd && word[end-1] == ')' {
+		end--
+	}
+
+	if start < end {
+		value := string(word[start:end])
+		wordToken := token{kind: tokenCheck, value: value, column: column + start}
+		switch strings.ToLower(value) {
+		case "and":
+			wordToken.kind = tokenAnd
+		case "or":
+			wordToken.kind = tokenOr
+		case "not":
+			wordToken.kind = tokenNot
+		}
+		tokens = append(tokens, wordToken)
+	}
+
+	for index := end; index < len(word); index++ {
+		tokens = append(tokens, token{kind: tokenRightParenthesis, value: ")", column: column + index})
+	}
+	return tokens
+}
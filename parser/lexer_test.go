@@ -0,0 +1,141 @@
+package oslopolicy2rego
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []token
+	}{
+		{"", []token{{kind: tokenEnd, column: 1}}},
+		{"role:admin", []token{
+			{kind: tokenCheck, value: "role:admin", column: 1},
+			{kind: tokenEnd, column: 11},
+		}},
+		{"((rule:a  AND\tnot rule:b) Or\nrule:c)", []token{
+			{kind: tokenLeftParenthesis, value: "(", column: 1},
+			{kind: tokenLeftParenthesis, value: "(", column: 2},
+			{kind: tokenCheck, value: "rule:a", column: 3},
+			{kind: tokenAnd, value: "AND", column: 11},
+			{kind: tokenNot, value: "not", column: 15},
+			{kind: tokenCheck, value: "rule:b", column: 19},
+			{kind: tokenRightParenthesis, value: ")", column: 25},
+			{kind: tokenOr, value: "Or", column: 27},
+			{kind: tokenCheck, value: "rule:c", column: 30},
+			{kind: tokenRightParenthesis, value: ")", column: 36},
+			{kind: tokenEnd, column: 37},
+		}},
+		{"project:%(target.id)s)", []token{
+			{kind: tokenCheck, value: "project:%(target.id)s", column: 1},
+			{kind: tokenRightParenthesis, value: ")", column: 22},
+			{kind: tokenEnd, column: 23},
+		}},
+		{"rôle:ädmin or", []token{
+			{kind: tokenCheck, value: "rôle:ädmin", column: 1},
+			{kind: tokenOr, value: "or", column: 12},
+			{kind: tokenEnd, column: 14},
+		}},
+		{"('a (b)':x or name:\"c d)\")", []token{
+			{kind: tokenLeftParenthesis, value: "(", column: 1},
+			{kind: tokenCheck, value: "'a (b)':x", column: 2},
+			{kind: tokenOr, value: "or", column: 12},
+			{kind: tokenCheck, value: "name:\"c d)\"", column: 15},
+			{kind: tokenRightParenthesis, value: ")", column: 26},
+			{kind: tokenEnd, column: 27},
+		}},
+		{"role:'admin or name:s-team)", []token{
+			{kind: tokenCheck, value: "role:'admin", column: 1},
+			{kind: tokenOr, value: "or", column: 13},
+			{kind: tokenCheck, value: "name:s-team", column: 16},
+			{kind: tokenRightParenthesis, value: ")", column: 27},
+			{kind: tokenEnd, column: 28},
+		}},
+		{"name:o'brien)", []token{
+			{kind: tokenCheck, value: "name:o'brien", column: 1},
+			{kind: tokenRightParenthesis, value: ")", column: 13},
+			{kind: tokenEnd, column: 14},
+		}},
+	}
+	for _, c := range cases {
+		got := tokenize(c.input)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("tokenize() with input: %q\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, got)
+		}
+	}
+}
+
+// The kind of the quoted strings of oslo.policy, which it fails to parse.
+const osloTokenString tokenKind = -1
+
+// osloTokenize is a port of the tokenizer of oslo.policy (_parse_tokenize),
+// which the tokenizer is compared with. It splits the expression at
+// whitespace before looking at quotes, and a word that is quoted as a whole
+// is a string. The columns are left out.
+func osloTokenize(expression string) []token {
+	var tokens []token
+	for _, word := range regexp.MustCompile(`\s+`).Split(expression, -1) {
+		clean := strings.TrimLeft(word, "(")
+		for i := 0; i < len(word)-len(clean); i++ {
+			tokens = append(tokens, token{kind: tokenLeftParenthesis, value: "("})
+		}
+		if clean == "" {
+			continue
+		}
+		word = clean
+		clean = strings.TrimRight(word, ")")
+		switch lowered := strings.ToLower(clean); {
+		case lowered == "and":
+			tokens = append(tokens, token{kind: tokenAnd, value: clean})
+		case lowered == "or":
+			tokens = append(tokens, token{kind: tokenOr, value: clean})
+		case lowered == "not":
+			tokens = append(tokens, token{kind: tokenNot, value: clean})
+		case clean == "":
+		case len(word) >= 2 && (word[0] == '\'' || word[0] == '"') && word[len(word)-1] == word[0]:
+			tokens = append(tokens, token{kind: osloTokenString, value: word[1 : len(word)-1]})
+		default:
+			tokens = append(tokens, token{kind: tokenCheck, value: clean})
+		}
+		for i := 0; i < len(word)-len(clean); i++ {
+			tokens = append(tokens, token{kind: tokenRightParenthesis, value: ")"})
+		}
+	}
+	return tokens
+}
+
+func TestTokenizeMatchesOslo(t *testing.T) {
+	cases := []struct {
+		input string
+		// Whitespace inside of quotes is an extension: oslo.policy splits
+		// the literal, and fails to parse the rule, which then never holds.
+		extension bool
+	}{
+		{"'member':%(target.role)s", false},
+		{`"member":%(target.role)s or rule:a`, false},
+		{`(name:'x)' or not name:"(y")`, false},
+		{"name:'a)b)' and (role:c)", false},
+		{"name:o'brien)", false},
+		{"role:'admin or name:s-team)", false},
+		{"(name:'s-team)", false},
+		{"'my role':%(target.name)s", true},
+		{"name:'a (b) or c' and rule:d", true},
+		{"('a (b)':x or name:\"c d)\")", true},
+	}
+	for _, c := range cases {
+		tokens := tokenize(c.input)
+		var got []token
+		for _, tok := range tokens[:len(tokens)-1] {
+			got = append(got, token{kind: tok.kind, value: tok.value})
+		}
+		oslo := osloTokenize(c.input)
+		if reflect.DeepEqual(got, oslo) == c.extension {
+			t.Errorf("tokenize() with input: %q\nGot: %v\noslo.policy got: %v", c.input, got, oslo)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package oslopolicy2rego
+
+import (
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The digits of a python number, which may be separated by underscores.
+const pythonDigits = `[0-9](_?[0-9])*`
+
+var pythonIntegerRegexp = regexp.MustCompile(`^[+-]?(0[xX](_?[0-9a-fA-F])+|0[oO](_?[0-7])+|` +
+	`0[bB](_?[01])+|0(_?0)*|[1-9](_?[0-9])*)$`)
+
+var pythonFloatRegexp = regexp.MustCompile(`^[+-]?((` + pythonDigits + `\.(` + pythonDigits + `)?|\.` +
+	pythonDigits + `)([eE][+-]?` + pythonDigits + `)?|` + pythonDigits + `[eE][+-]?` + pythonDigits + `)$`)
+
+// pythonConstantString evaluates the given value as a python literal, the
+// way ast.literal_eval does, and returns the string that str() gives for it.
+// This is what oslo.policy compares the match of a check with when the kind
+// is a constant, e.g. "0x10" gives "16", "-1.50" gives "-1.5" and "None"
+// gives "None". Quoted strings are handled apart. If the value isn't a
+// literal, the second output is false.
+func pythonConstantString(value string) (string, bool) {
+	switch value {
+	case "True", "False", "None":
+		return value, true
+	}
+	if pythonIntegerRegexp.MatchString(value) {
+		return pythonIntegerString(value), true
+	} else if pythonFloatRegexp.MatchString(value) {
+		return pythonFloatString(value), true
+	}
+	return "", false
+}
+
+// pythonIntegerString formats a python integer literal in base ten. Python
+// integers have no size limit, so neither does this.
+func pythonIntegerString(value string) string {
+	value = strings.ReplaceAll(value, "_", "")
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(value, "+-")
+
+	base := 10
+	if len(value) > 1 && value[0] == '0' {
+		switch value[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 10 {
+			value = value[2:]
+		}
+	}
+
+	number, _ := new(big.Int).SetString(value, base)
+	if negative {
+		number.Neg(number)
+	}
+	return number.String()
+}
+
+// pythonFloatString formats a python float literal the way python's repr()
+// does: with the shortest digits that give back the same number, always with
+// a decimal point, and in scientific notation if the exponent is below -4 or
+// above 15.
+func pythonFloatString(value string) string {
+	number, _ := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64)
+	if math.IsInf(number, 1) {
+		return "inf"
+	} else if math.IsInf(number, -1) {
+		return "-inf"
+	}
+
+	scientific := strconv.FormatFloat(number, 'e', -1, 64)
+	exponent, _ := strconv.Atoi(scientific[strings.IndexByte(scientific, 'e')+1:])
+	if exponent < -4 || exponent >= 16 {
+		return scientific
+	}
+	decimal := strconv.FormatFloat(number, 'f', -1, 64)
+	if !strings.Contains(decimal, ".") {
+		decimal += ".0"
+	}
+	return decimal
+}
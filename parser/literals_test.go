@@ -0,0 +1,60 @@
+package oslopolicy2rego
+
+import (
+	"testing"
+)
+
+func TestPythonConstantString(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"True", "True"},
+		{"False", "False"},
+		{"None", "None"},
+		{"0", "0"},
+		{"-1", "-1"},
+		{"+1", "1"},
+		{"-0", "0"},
+		{"1_000", "1000"},
+		{"0x1F", "31"},
+		{"-0o17", "-15"},
+		{"0b101", "5"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"1.5", "1.5"},
+		{"-1.50", "-1.5"},
+		{"1.", "1.0"},
+		{".5", "0.5"},
+		{"-0.0", "-0.0"},
+		{"010.5", "10.5"},
+		{"1e3", "1000.0"},
+		{"1_0.2_5", "10.25"},
+		{"0.0001", "0.0001"},
+		{"0.00001", "1e-05"},
+		{"1e16", "1e+16"},
+		{"1234567890123456.0", "1234567890123456.0"},
+		{"1.5E-7", "1.5e-07"},
+		{"1e400", "inf"},
+		{"-1e400", "-inf"},
+	}
+	for _, c := range cases {
+		got, ok := pythonConstantString(c.input)
+		if !ok {
+			t.Errorf("pythonConstantString() with input: %s\nWasn't taken as a literal", c.input)
+		} else if got != c.want {
+			t.Errorf("pythonConstantString() with input: %s\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, got)
+		}
+	}
+}
+
+func TestPythonConstantStringRejectsNonLiterals(t *testing.T) {
+	for _, input := range []string{
+		"project_id", "true", "none", "010", "1__0", "_1", "1_", "0x", "1e", "--1",
+		"inf", "nan", "1j", "1.5.2", ".", "-", "is_admin.1",
+	} {
+		if got, ok := pythonConstantString(input); ok {
+			t.Errorf("pythonConstantString() with input: %s\nShouldn't be a literal, got: %v", input, got)
+		}
+	}
+}
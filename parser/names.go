@@ -0,0 +1,81 @@
+package oslopolicy2rego
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The prefix of the names of the sub-rules that the renderer generates.
+const subRulePrefix = "openstack_rule"
+
+// Names that rules can't take in the generated policy, either because they are
+// rego keywords, or because they are already used by the rego policy itself
+// (its imports, the allow rule, the set of actions, the conversion functions,
+// the variable it iterates the roles with or the built-in functions it calls).
+var reservedRuleNames = map[string]bool{
+	"actions": true, "allow": true, "credentials": true, "data": true,
+	"input": true, "role": true, "rule": true, "target": true,
+	"openstack_str": true, "openstack_values": true,
+	"http": true, "json": true, "lower": true, "sprintf": true,
+	"is_array": true, "is_null": true, "is_number": true, "is_string": true,
+	"trim": true, "urlquery": true,
+}
+
+var invalidIdentifierCharsRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ruleNameIsUsable tells if the given name can be used as is as the name of a
+// rule in the generated policy. Names made only of underscores aren't, as "_"
+// is the wildcard variable of rego.
+func ruleNameIsUsable(name string) bool {
+	return regoIdentifierRegexp.MatchString(name) &&
+		!regoKeywords[name] && !reservedRuleNames[name] &&
+		!strings.HasPrefix(name, subRulePrefix+"_") &&
+		strings.Trim(name, "_") != ""
+}
+
+// mangleRuleName turns the given name into a valid rego identifier that
+// doesn't clash with the reserved names. e.g. "admin-or-owner" becomes
+// "admin_or_owner" and "default" becomes "rule_default".
+func mangleRuleName(name string) string {
+	if ruleNameIsUsable(name) {
+		return name
+	}
+	mangledName := invalidIdentifierCharsRegexp.ReplaceAllString(name, "_")
+	if !ruleNameIsUsable(mangledName) {
+		mangledName = "rule_" + mangledName
+	}
+	return mangledName
+}
+
+// regoRuleNames maps the given rule names to the names used for them in the
+// generated policy. Names that are valid identifiers keep them, the rest are
+// mangled, and if the mangled name is already taken a numeric suffix is
+// added. The names are processed sorted, so the mapping is always the same
+// for the same set of names whatever order they are given in.
+func regoRuleNames(names []string) map[string]string {
+	sortedNames := append([]string(nil), names...)
+	sort.Strings(sortedNames)
+
+	mapping := make(map[string]string)
+	taken := make(map[string]bool)
+	for _, name := range sortedNames {
+		if ruleNameIsUsable(name) {
+			mapping[name] = name
+			taken[name] = true
+		}
+	}
+	for _, name := range sortedNames {
+		if _, ok := mapping[name]; ok {
+			continue
+		}
+		mangledName := mangleRuleName(name)
+		for suffix := 2; taken[mangledName]; suffix++ {
+			mangledName = fmt.Sprintf("%s_%d", mangleRuleName(name), suffix)
+		}
+		mapping[name] = mangledName
+		taken[mangledName] = true
+	}
+	return mapping
+}
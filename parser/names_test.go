@@ -0,0 +1,78 @@
+package oslopolicy2rego
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMangleRuleName(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"admin", "admin"},
+		{"admin_or_owner", "admin_or_owner"},
+		{"admin-or-owner", "admin_or_owner"},
+		{"default", "rule_default"},
+		{"not", "rule_not"},
+		{"allow", "rule_allow"},
+		{"credentials", "rule_credentials"},
+		{"target", "rule_target"},
+		{"lower", "rule_lower"},
+		{"2fa", "rule_2fa"},
+		{"openstack_rule_1234", "rule_openstack_rule_1234"},
+		{"", "rule_"},
+		{"_", "rule__"},
+		{"__", "rule___"},
+		{"-", "rule__"},
+		{"_a", "_a"},
+	}
+	for _, c := range cases {
+		got := mangleRuleName(c.input)
+		if got != c.want {
+			t.Errorf("mangleRuleName() with input: %s\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, got)
+		}
+	}
+}
+
+func TestRegoRuleNamesAvoidsCollisions(t *testing.T) {
+	names := []string{"admin-owner", "admin_owner", "admin.owner", "default", "rule_default"}
+	want := map[string]string{
+		"admin_owner":  "admin_owner",
+		"rule_default": "rule_default",
+		"admin-owner":  "admin_owner_2",
+		"admin.owner":  "admin_owner_3",
+		"default":      "rule_default_2",
+	}
+	got := regoRuleNames(names)
+	for name, wantedName := range want {
+		if got[name] != wantedName {
+			t.Errorf("regoRuleNames() for name %s didn't match %v\nInstead got: %v",
+				name, wantedName, got[name])
+		}
+	}
+
+	// The mapping can't depend on the order of the names
+	reversedNames := []string{"rule_default", "default", "admin.owner", "admin_owner", "admin-owner"}
+	reversed := regoRuleNames(reversedNames)
+	for name, wantedName := range want {
+		if reversed[name] != wantedName {
+			t.Errorf("regoRuleNames() with reversed input for name %s didn't match %v\nInstead got: %v",
+				name, wantedName, reversed[name])
+		}
+	}
+}
+
+func TestOsloPolicy2RegoDoesntNameRulesAfterTheWildcard(t *testing.T) {
+	got, err := OsloPolicy2Rego("openstack.policy", `{"-": "role:a", "x:y": "rule:-"}`)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	want := `x_y {
+    rule__
+}`
+	if !strings.Contains(got, want) {
+		t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", want, got)
+	}
+}
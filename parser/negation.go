@@ -0,0 +1,44 @@
+package oslopolicy2rego
+
+// negationNormalForm returns a check equivalent to the given one in which only
+// single checks are negated. Negations are pushed into the groups with De
+// Morgan's laws, e.g. "not (rule:a or rule:b)" becomes
+// "not rule:a and not rule:b", double negations cancel out, and negated
+// constants are folded. Rego can only negate single expressions, so this lets
+// every negation be rendered as a plain "not" assertion.
+func negationNormalForm(check Check) Check {
+	switch typedCheck := check.(type) {
+	case AndCheck:
+		return AndCheck{Checks: mapChecks(typedCheck.Checks, negationNormalForm)}
+	case OrCheck:
+		return OrCheck{Checks: mapChecks(typedCheck.Checks, negationNormalForm)}
+	case NotCheck:
+		return negate(typedCheck.Check)
+	}
+	return check
+}
+
+// negate returns the negation normal form of the negation of the given check.
+func negate(check Check) Check {
+	switch typedCheck := check.(type) {
+	case AndCheck:
+		return OrCheck{Checks: mapChecks(typedCheck.Checks, negate)}
+	case OrCheck:
+		return AndCheck{Checks: mapChecks(typedCheck.Checks, negate)}
+	case NotCheck:
+		return negationNormalForm(typedCheck.Check)
+	case TrueCheck:
+		return FalseCheck{}
+	case FalseCheck:
+		return TrueCheck{}
+	}
+	return NotCheck{Check: check}
+}
+
+func mapChecks(checks []Check, transform func(Check) Check) []Check {
+	transformed := make([]Check, 0, len(checks))
+	for _, check := range checks {
+		transformed = append(transformed, transform(check))
+	}
+	return transformed
+}
@@ -0,0 +1,151 @@
+package oslopolicy2rego
+
+import (
+	"strings"
+	"testing"
+)
+
+// evaluateCheck evaluates the given check the way oslo.policy does, taking
+// the results of the "rule:" checks from the given map.
+func evaluateCheck(check Check, rules map[string]bool) bool {
+	switch typedCheck := check.(type) {
+	case AndCheck:
+		for _, subCheck := range typedCheck.Checks {
+			if !evaluateCheck(subCheck, rules) {
+				return false
+			}
+		}
+		return true
+	case OrCheck:
+		for _, subCheck := range typedCheck.Checks {
+			if evaluateCheck(subCheck, rules) {
+				return true
+			}
+		}
+		return false
+	case NotCheck:
+		return !evaluateCheck(typedCheck.Check, rules)
+	case RuleCheck:
+		return rules[typedCheck.Match]
+	case TrueCheck:
+		return true
+	}
+	return false
+}
+
+// onlySingleChecksNegated tells if the given check is in negation normal form.
+func onlySingleChecksNegated(check Check) bool {
+	switch typedCheck := check.(type) {
+	case AndCheck:
+		for _, subCheck := range typedCheck.Checks {
+			if !onlySingleChecksNegated(subCheck) {
+				return false
+			}
+		}
+	case OrCheck:
+		for _, subCheck := range typedCheck.Checks {
+			if !onlySingleChecksNegated(subCheck) {
+				return false
+			}
+		}
+	case NotCheck:
+		switch typedCheck.Check.(type) {
+		case AndCheck, OrCheck, NotCheck, TrueCheck, FalseCheck:
+			return false
+		}
+	}
+	return true
+}
+
+func TestNegationNormalForm(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"not rule:a", "not rule:a"},
+		{"not not rule:a", "rule:a"},
+		{"not not not rule:a", "not rule:a"},
+		{"not (rule:a or rule:b)", "(not rule:a and not rule:b)"},
+		{"not (rule:a and rule:b)", "(not rule:a or not rule:b)"},
+		{"not (rule:a and not (rule:b or rule:c))", "(not rule:a or (rule:b or rule:c))"},
+		{"rule:a and not (not rule:b or @)", "(rule:a and (rule:b and !))"},
+		{"not !", "@"},
+	}
+	for _, c := range cases {
+		check, err := ParseCheck(c.input)
+		if err != nil {
+			t.Errorf("ParseCheck() with input: %s\nFailed with: %v", c.input, err)
+			continue
+		}
+		if got := negationNormalForm(check).String(); got != c.want {
+			t.Errorf("negationNormalForm() with input: %s\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, got)
+		}
+	}
+}
+
+// The negation normal form has to give the same results as oslo.policy does
+// for the original check, whatever the referenced rules give.
+func TestNegationNormalFormKeepsOsloResults(t *testing.T) {
+	inputs := []string{
+		"not (rule:a or rule:b)",
+		"not (rule:a and rule:b)",
+		"not not rule:a",
+		"not (rule:a and not (rule:b or not rule:c))",
+		"not ((rule:a or rule:b) and (not rule:b or rule:c))",
+		"rule:a or not (rule:b and rule:c) and not not rule:a",
+		"not (@ and rule:a) or not (! or rule:b)",
+	}
+	names := []string{"a", "b", "c"}
+	for _, input := range inputs {
+		check, err := ParseCheck(input)
+		if err != nil {
+			t.Errorf("ParseCheck() with input: %s\nFailed with: %v", input, err)
+			continue
+		}
+		normalized := negationNormalForm(check)
+		if !onlySingleChecksNegated(normalized) {
+			t.Errorf("negationNormalForm() with input: %s\nNegated a group: %v", input, normalized)
+		}
+		for assignment := 0; assignment < 1<<len(names); assignment++ {
+			rules := make(map[string]bool)
+			for index, name := range names {
+				rules[name] = assignment&(1<<index) != 0
+			}
+			want := evaluateCheck(check, rules)
+			if got := evaluateCheck(normalized, rules); got != want {
+				t.Errorf("negationNormalForm() with input: %s\nGave %v instead of %v with %v, as %v",
+					input, got, want, rules, normalized)
+			}
+		}
+	}
+}
+
+func TestOsloPolicy2RegoRendersNegatedGroups(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []string
+	}{
+		{"not (rule:a or rule:b)", []string{"x {\n    not a\n    not b\n}"}},
+		{"not (rule:a and rule:b)", []string{"x {\n    not a\n}\nx {\n    not b\n}"}},
+		{"not not rule:a", []string{"x {\n    a\n}"}},
+		{"role:r and not (rule:a and rule:b)", []string{"x {\n    lower(credentials.roles[_]) = \"r\"\n    openstack_rule_"}},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2Rego("openstack.policy", `{"a": "@", "b": "@", "x": "`+c.input+`"}`)
+		if err != nil {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nFailed with: %v", c.input, err)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("OsloPolicy2Rego() with input: %s\nDidn't contain:\n%s\nGot:\n%s", c.input, want, got)
+			}
+		}
+		// The negations are pushed down to single checks, so no sub-rule
+		// is ever negated
+		if strings.Contains(got, "not "+subRulePrefix) {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nNegated a sub-rule:\n%s", c.input, got)
+		}
+	}
+}
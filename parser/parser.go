@@ -1,512 +1,426 @@
 package oslopolicy2rego
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
-	"math/rand"
 	"regexp"
-	"strconv"
 	"strings"
-	"text/template"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v2"
 )
 
-const policyHeaderTemplate = `
-package {{.Package}}
-
-import input.credentials as credentials
-import input.rule as rule
-import input.target as target
-
-default allow = false
-`
-
-const actionTemplate = `allow {
-    rule = "{{.Name}}"
-    {{.Expression}}
-}`
-
-const aliasTemplate = `{{.Name}} {
-    {{.Expression}}
-}`
-
-type expression struct {
-	assertions []string
-}
-
-type regoRule struct {
-	RuleType   string
-	Name       string
-	Expression expression
-}
-
-// This contains the actual list of rules
-type regoRules []regoRule
-
-type tokenParserStateFunction func(string, bool, *osloParserState) (*regoRule, error)
-
-type osloParserState struct {
-	rulesStack    []regoRule
-	prefix        string
-	nextOperation tokenParserStateFunction
-}
-
-// Wrapper struct to write the template
-type osloParser struct {
-	Package string
-	Rules   regoRules
-	Tmpl    *template.Template
-}
-
-func (e expression) String() string {
-	return strings.Join(e.assertions, "\n    ")
+// checkParser is a recursive-descent parser for oslo.policy expressions.
+// It follows oslo.policy's grammar, where "not" binds tighter than "and",
+// which binds tighter than "or":
+//
+//	expression := and_expression ("or" and_expression)*
+//	and_expression := not_expression ("and" not_expression)*
+//	not_expression := "not" not_expression | "(" expression ")" | check
+type checkParser struct {
+	expression string
+	tokens     []token
+	position   int
+	// The errors that the parser recovered from.
+	errors PolicyErrors
+}
+
+// Returns the token being looked at.
+func (p *checkParser) peek() token {
+	return p.tokens[p.position]
+}
+
+// Returns the token being looked at and advances to the next one.
+func (p *checkParser) next() token {
+	current := p.tokens[p.position]
+	if current.kind != tokenEnd {
+		p.position++
+	}
+	return current
 }
 
-func (o osloParserState) Len() int {
-	return len(o.rulesStack)
+// errorAt returns an error of the given kind with the given message, which
+// points at the given column of the expression.
+func (p *checkParser) errorAt(column int, kind ErrorKind, message string) *PolicyError {
+	return &PolicyError{Kind: kind, Expression: p.expression, Column: column, Message: message}
 }
 
-func (o *osloParserState) addPrefix(prefix string) {
-	o.prefix = prefix
+// recover keeps the given error, and returns a check that takes the place of
+// the one that failed, so that the rest of the expression can still be
+// parsed and checked for errors.
+func (p *checkParser) recover(err *PolicyError) Check {
+	p.errors = append(p.errors, err)
+	return FalseCheck{}
 }
 
-func (o *osloParserState) addAssertion(assertion string) {
-	lastIndex := len(o.rulesStack) - 1
-	assertions := o.rulesStack[lastIndex].Expression.assertions
-
-	prefix := ""
-	if o.prefix != "" {
-		prefix = o.prefix + " "
+func (p *checkParser) parseExpression() (Check, error) {
+	check, err := p.parseAndExpression()
+	if err != nil {
+		return nil, err
 	}
-
-	assertion = prefix + assertion
-	assertions = append(assertions, assertion)
-
-	o.rulesStack[lastIndex].Expression.assertions = assertions
-	o.prefix = ""
-}
-
-func (o *osloParserState) push(v regoRule) {
-	o.rulesStack = append(o.rulesStack, v)
-}
-
-func (o *osloParserState) pop() (regoRule, error) {
-	l := len(o.rulesStack)
-	if l == 0 {
-		return regoRule{}, errors.New("Can't pop from an empty stack")
+	alternatives := []Check{check}
+	for p.peek().kind == tokenOr {
+		p.next()
+		check, err := p.parseAndExpression()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, check)
 	}
-	rule := o.rulesStack[l-1]
-	o.rulesStack = o.rulesStack[:l-1]
-	return rule, nil
-}
-
-// Initialized the osloParser object. This involves initializing the template
-// objects in order to render the rego rules.
-func (o *osloParser) Init() error {
-	tmpl, _ := template.New("Header").Parse(policyHeaderTemplate)
-	tmpl, _ = tmpl.New("Action").Parse(actionTemplate)
-	tmpl, _ = tmpl.New("Alias").Parse(aliasTemplate)
-
-	o.Tmpl = tmpl
-	return nil
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return OrCheck{Checks: alternatives}, nil
 }
 
-// renders the named rego segment related to the templateName. Currently we
-// only have two: Action, Alias
-func (o osloParser) renderTemplate(templateName string, outputStruct interface{}) string {
-	var render bytes.Buffer
-
-	err := o.Tmpl.ExecuteTemplate(&render, templateName, outputStruct)
-
+func (p *checkParser) parseAndExpression() (Check, error) {
+	check, err := p.parseNotExpression()
 	if err != nil {
-		return ""
+		return nil, err
 	}
-
-	return render.String()
-}
-
-func (o osloParser) renderRuleEntry(rule regoRule) string {
-	return o.renderTemplate(rule.RuleType, rule)
-}
-
-func (o osloParser) String() string {
-	var outputPolicies []string
-	for _, rule := range o.Rules {
-		outputPolicies = append(outputPolicies, o.renderRuleEntry(rule))
+	operands := []Check{check}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		check, err := p.parseNotExpression()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, check)
 	}
-	return o.renderTemplate("Header", o) + strings.Join(outputPolicies, "\n")
+	return andOf(operands), nil
 }
 
-func (o *osloParser) parseExpression(baseRule regoRule, value interface{}) ([]regoRule, error) {
-	var outputRules []regoRule
-	switch typedValue := value.(type) {
-	case string:
-		if typedValue == "!" {
-			baseRule.Expression = createSimpleExpression("false")
-			outputRules = append(outputRules, baseRule)
-			return outputRules, nil
-		} else if typedValue == "" || typedValue == "@" {
-			baseRule.Expression = createSimpleExpression("true")
-			outputRules = append(outputRules, baseRule)
-			return outputRules, nil
-		}
-		baseRule.Expression = expression{}
-		state := osloParserState{nextOperation: expectStart}
-		state.push(baseRule)
-		token := ""
-		token, typedValue = tokenize(typedValue)
-
-		for token != "" {
-			outputRule, err := state.nextOperation(token, false, &state)
-			if err != nil {
-				return nil, err
-			}
-			if outputRule != nil {
-				outputRules = append(outputRules, *outputRule)
-			}
-			token, typedValue = tokenize(typedValue)
-		}
-
-		outputRule, err := state.nextOperation("", true, &state)
+func (p *checkParser) parseNotExpression() (Check, error) {
+	current := p.next()
+	switch current.kind {
+	case tokenNot:
+		check, err := p.parseNotExpression()
 		if err != nil {
 			return nil, err
 		}
-		if outputRule != nil {
-			outputRules = append(outputRules, *outputRule)
+		return NotCheck{Check: check}, nil
+	case tokenLeftParenthesis:
+		check, err := p.parseExpression()
+		if err != nil {
+			return nil, err
 		}
-	case []interface{}:
-		if len(typedValue) == 0 {
-			baseRule.Expression = createSimpleExpression("true")
-			outputRules = append(outputRules, baseRule)
-			return outputRules, nil
-		} else {
-			return nil, errors.New("Can't give non-empty lists as values")
+		closing := p.next()
+		if closing.kind == tokenEnd {
+			return nil, p.errorAt(current.column, ErrorUnclosedParenthesis, "Unclosed parenthesis")
+		} else if closing.kind != tokenRightParenthesis {
+			return nil, p.errorAt(closing.column, ErrorUnexpectedToken, "Expected \"and\", \"or\" or \")\" instead of "+closing.String())
 		}
-	default:
-		errorMessage := fmt.Sprintf("The value %v is invalid", typedValue)
-		return nil, errors.New(errorMessage)
-	}
-	return outputRules, nil
-}
-
-// parseRules parses the rules from the given map and persists them on to the
-// Rules entry of the osloParser object.
-func (o *osloParser) parseRules(rules map[string]interface{}) error {
-	var rulesList []regoRule
-
-	for key, value := range rules {
-		ruleType := ""
-		if strings.Contains(key, ":") {
-			ruleType = "Action"
-		} else {
-			ruleType = "Alias"
+		return check, nil
+	case tokenCheck:
+		if current.value == "@" {
+			return TrueCheck{}, nil
+		} else if current.value == "!" {
+			return FalseCheck{}, nil
+		} else if !strings.Contains(current.value, ":") {
+			return p.recover(p.errorAt(current.column, ErrorUnexpectedToken, "Unexpected token "+current.String()+", checks are written as kind:match")), nil
 		}
-		rule := regoRule{RuleType: ruleType, Name: key}
-		rules, err := o.parseExpression(rule, value)
+		check, err := parseCheck(current.value)
 		if err != nil {
-			errorMessage := fmt.Sprintf("Error in key %s: \"%v\"", key, err)
-			return errors.New(errorMessage)
+			checkError := err.(*PolicyError)
+			// The error may point at a column of the check itself
+			column := current.column
+			if checkError.Column > 0 {
+				column += checkError.Column - 1
+			}
+			return p.recover(p.errorAt(column, checkError.Kind, checkError.Message)), nil
 		}
-		rulesList = append(rulesList, rules...)
+		return check, nil
+	case tokenEnd:
+		return nil, p.errorAt(current.column, ErrorUnexpectedEnd, "Unexpected end of expression")
 	}
-
-	o.Rules = rulesList
-	return nil
+	return nil, p.errorAt(current.column, ErrorUnexpectedToken, "Unexpected token "+current.String())
 }
 
-// Returns a random alias name with the named prefix
-func randomAliasName(prefix string) string {
-	return fmt.Sprintf("%s_%d", prefix, rand.Int())
-}
-
-func createSimpleExpression(value string) expression {
-	simpleExpression := expression{}
-	simpleExpression.assertions = append(simpleExpression.assertions, value)
-	return simpleExpression
+func andOf(operands []Check) Check {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	return AndCheck{Checks: operands}
 }
 
-func createSubRule() regoRule {
-	subRule := regoRule{RuleType: "Alias", Name: randomAliasName("openstack_rule")}
-	subRule.Expression = expression{}
-	return subRule
-}
+// parseExpression parses the value of a policy entry, which is either a
+// string with an oslo.policy expression or a list in the legacy list-of-lists
+// syntax.
+func parseExpression(value interface{}) (Check, error) {
+	switch typedValue := value.(type) {
+	case string:
+		return ParseCheck(typedValue)
+	case []interface{}:
+		return parseListRule(typedValue)
+	}
+	errorMessage := fmt.Sprintf("The value %v is invalid", value)
+	return nil, &PolicyError{Kind: ErrorInvalidValue, Message: errorMessage}
+}
+
+// parseListRule parses a rule in the legacy list-of-lists syntax, e.g.
+// [["role:admin"], ["project_id:%(project_id)s", "role:member"]]. The checks
+// of the inner lists are joined with "and", and the inner lists themselves
+// with "or". Like in oslo.policy, an empty list always holds, a list with only
+// empty lists (or empty strings) never holds, and a string can be given instead
+// of an inner list with a single check.
+func parseListRule(rule []interface{}) (Check, error) {
+	if len(rule) == 0 {
+		return TrueCheck{}, nil
+	}
 
-func newRule(baseRule regoRule) regoRule {
-	rule := regoRule{RuleType: baseRule.RuleType, Name: baseRule.Name}
-	rule.Expression = expression{}
-	return rule
-}
+	var alternatives []Check
+	var errs PolicyErrors
+	for _, innerRule := range rule {
+		var checks []interface{}
+		switch typedInnerRule := innerRule.(type) {
+		case string:
+			if typedInnerRule == "" {
+				// Like in oslo.policy, empty inner rules are skipped
+				continue
+			}
+			checks = []interface{}{typedInnerRule}
+		case []interface{}:
+			checks = typedInnerRule
+		default:
+			errorMessage := fmt.Sprintf("The value %v is invalid", innerRule)
+			errs = append(errs, &PolicyError{Kind: ErrorInvalidValue, Message: errorMessage})
+			continue
+		}
+		if len(checks) == 0 {
+			continue
+		}
 
-func valueIsQuotedString(stringValue string) bool {
-	if stringValue[0] == '\'' && stringValue[len(stringValue)-1] == '\'' {
-		return true
+		var operands []Check
+		for _, value := range checks {
+			stringValue, ok := value.(string)
+			if !ok {
+				errorMessage := fmt.Sprintf("The value %v is invalid", value)
+				errs = append(errs, &PolicyError{Kind: ErrorInvalidValue, Message: errorMessage})
+				continue
+			}
+			check, err := parseListCheck(stringValue)
+			if err != nil {
+				errs = append(errs, asPolicyErrors(err)...)
+				continue
+			}
+			operands = append(operands, check)
+		}
+		if len(operands) > 0 {
+			alternatives = append(alternatives, andOf(operands))
+		}
 	}
-	return false
-}
 
-func valueIsNumber(stringValue string) bool {
-	_, err := strconv.ParseInt(stringValue, 0, 64)
-	if err != nil {
-		return false
+	if len(errs) > 0 {
+		return nil, errs
+	} else if len(alternatives) == 0 {
+		return FalseCheck{}, nil
+	} else if len(alternatives) == 1 {
+		return alternatives[0], nil
 	}
-	return true
-}
-
-func valueIsBoolean(stringValue string) bool {
-	if stringValue == "True" || stringValue == "False" {
-		return true
+	return OrCheck{Checks: alternatives}, nil
+}
+
+// parseListCheck parses one of the checks given in the list-of-lists syntax.
+// These are single checks, they can't contain operators or parentheses.
+func parseListCheck(value string) (Check, error) {
+	if value == "!" {
+		return FalseCheck{}, nil
+	} else if value == "@" {
+		return TrueCheck{}, nil
+	} else if !strings.Contains(value, ":") {
+		errorMessage := fmt.Sprintf("Unexpected token: %v", value)
+		return nil, &PolicyError{Kind: ErrorUnexpectedToken, Expression: value, Message: errorMessage}
 	}
-	return false
-}
-
-// Renders the comparison between two values. If they haven't matched a type,
-// they are assumed to come from the credentials, so we render it as such. If
-// they matched a type we render the value as was given.
-func renderComparison(leftValue string, leftMatched bool,
-	rightValue string, rightMatched bool) string {
-	if leftMatched && rightMatched {
-		return leftValue + " = " + rightValue
-	} else if !leftMatched && rightMatched {
-		return "credentials." + leftValue + " = " + rightValue
-	} else if leftMatched && !rightMatched {
-		return "credentials." + rightValue + " = " + leftValue
+	return parseCheck(value)
+}
+
+// parseRules parses the rules from the given map into the policy, keeping the
+// order in which they were given. Every rule is parsed even if some fail, so
+// that all the errors are reported at once. The rules that fail get a
+// FalseCheck, so that they never hold.
+func parseRules(rules yaml.MapSlice) (*Policy, error) {
+	policy := &Policy{expressions: make(map[string]string)}
+	ruleIndexes := make(map[string]int)
+	var errs PolicyErrors
+
+	// Like in oslo.policy, if a key is repeated, the last value wins, so
+	// the values before it aren't even parsed.
+	lastItems := make(map[string]int)
+	for itemIndex, item := range rules {
+		if key, ok := item.Key.(string); ok {
+			lastItems[key] = itemIndex
+		}
 	}
-	return "credentials." + leftValue + " = \"" + rightValue + "\""
-}
 
-// Renders a constant value which is used in a comparison. This will return the
-// rendered value (as it should be persisted in rego), or will just output the
-// value as the result. If the value didn't match any of the types boolean,
-// string or number, the second boolean output will be set to false, indicating
-// that no match was found.
-func renderConstantForComparison(value string) (string, bool) {
-	if valueIsBoolean(value) {
-		return strings.ToLower(value), true
-	} else if valueIsNumber(value) {
-		return value, true
-	} else if valueIsQuotedString(value) {
-		return "\"" + value[1:len(value)-1] + "\"", true
+	for itemIndex, item := range rules {
+		key, ok := item.Key.(string)
+		if !ok {
+			errorMessage := fmt.Sprintf("The key %v is invalid, it must be a string", item.Key)
+			errs = append(errs, &PolicyError{Kind: ErrorInvalidKey, Message: errorMessage})
+			continue
+		}
+		index, defined := ruleIndexes[key]
+		if defined {
+			policy.duplicateKeys = append(policy.duplicateKeys, key)
+		} else {
+			// The rule keeps the position of the first value.
+			index = len(policy.Rules)
+			ruleIndexes[key] = index
+			policy.Rules = append(policy.Rules, Rule{Name: key})
+		}
+		if itemIndex != lastItems[key] {
+			continue
+		}
+		if expression, ok := item.Value.(string); ok {
+			policy.expressions[key] = expression
+		}
+		check, err := parseExpression(item.Value)
+		if err != nil {
+			errs = append(errs, inKey(key, err)...)
+			check = FalseCheck{}
+		}
+		policy.Rules[index].Check = check
 	}
 
-	return value, false
+	return policy, errorOrNil(errs)
 }
 
-// parses value comparisons, which can be:
-// * rule assertions
-// * role assertions
-// * comparing a value coming from the credentials with a value coming from the
-//   target
-// * Constant value comparison
-func parseComparison(value string) (string, error) {
-	comparedValues := strings.SplitN(value, ":", 2)
+// parses a single check, which can be:
+//   - rule assertions
+//   - role assertions
+//   - http checks, which ask a remote server
+//   - comparing a value coming from the credentials with a value coming from the
+//     target
+//   - Constant value comparison
+func parseCheck(value string) (Check, error) {
+	comparedValues := splitCheck(value)
 
 	if comparedValues[0] == "" {
 		errorMessage := fmt.Sprintf("You need to provide a left operand for the comparison: %v", value)
-		return "", errors.New(errorMessage)
+		return nil, &PolicyError{Kind: ErrorMissingOperand, Expression: value, Message: errorMessage}
 	} else if comparedValues[1] == "" {
 		errorMessage := fmt.Sprintf("You need to provide a right operand for the comparison: %v", value)
-		return "", errors.New(errorMessage)
+		return nil, &PolicyError{Kind: ErrorMissingOperand, Expression: value, Message: errorMessage}
 	} else if comparedValues[0] == "rule" {
-		// No need to render anything, pass the value as-is
-		return comparedValues[1], nil
-	} else if comparedValues[0] == "role" {
-		// Pass in role comparison, which will be rendered being gotten from
-		// the credentials. When none of the cases match it renders the right
-		// value as a quoted string, which is what we want in this case.
-		return renderComparison("roles[_]", false, comparedValues[1], false), nil
-	} else if strings.HasPrefix(comparedValues[1], "%(") {
-		targetValue := ""
-		if strings.HasSuffix(comparedValues[1], ")s") {
-			targetValue = "target." + comparedValues[1][2:len(comparedValues[1])-2]
-		} else {
-			errorMessage := fmt.Sprintf("Unmatched parentheses in value %v", value)
-			return "", errors.New(errorMessage)
-		}
-		leftValue, leftMatched := renderConstantForComparison(comparedValues[0])
-		return renderComparison(leftValue, leftMatched, targetValue, true), nil
+		return RuleCheck{Match: comparedValues[1]}, nil
 	}
 
-	leftValue, leftMatched := renderConstantForComparison(comparedValues[0])
-	rightValue, rightMatched := renderConstantForComparison(comparedValues[1])
-	return renderComparison(leftValue, leftMatched, rightValue, rightMatched), nil
-}
-
-func expectStart(token string, end bool, state *osloParserState) (*regoRule, error) {
-	if end {
-		return nil, errors.New("Unexpected end of expression.")
-	} else if token == "(" {
-		subRule := createSubRule()
-		state.addAssertion(subRule.Name)
-		state.push(subRule)
-		return nil, nil
-	} else if token == ")" {
-		currentRule, err := state.pop()
-		// We can't advance if we're in the base rule, it has to be a sub rule
-		if err != nil || state.Len() == 0 {
-			return nil, errors.New("Unexpected closing parenthesis.")
-		}
-		state.nextOperation = expectEndOrOperator
-		return &currentRule, err
-	} else if token == "not" {
-		state.addPrefix("not")
-		state.nextOperation = expectNextToken
-		return nil, nil
-	} else if strings.Contains(token, ":") {
-		assertion, err := parseComparison(token)
-		if err != nil {
-			return nil, err
-		}
-		state.addAssertion(assertion)
-		state.nextOperation = expectEndOrOperator
-		return nil, nil
+	// The match is formatted with the target, so its format is checked
+	// here, where its position is known.
+	match := comparedValues[1]
+	offset := utf8.RuneCountInString(comparedValues[0]) + 1
+	if comparedValues[0] == "http" || comparedValues[0] == "https" {
+		// The whole URL is formatted
+		match, offset = value, 0
+	} else if matchIsSingleQuoted(match) {
+		match, offset = match[1:len(match)-1], offset+1
 	}
-	errorMessage := fmt.Sprintf("Unexpected token: %v", token)
-	return nil, errors.New(errorMessage)
-}
-
-func expectNextToken(token string, end bool, state *osloParserState) (*regoRule, error) {
-	if end {
-		return nil, errors.New("Unexpected end of expression.")
-	} else if token == "(" {
-		subRule := createSubRule()
-		state.addAssertion(subRule.Name)
-		state.push(subRule)
-		return nil, nil
-	} else if token == ")" {
-		currentRule, err := state.pop()
-		// We can't advance if we're in the base rule, it has to be a sub rule
-		if err != nil || state.Len() == 0 {
-			return nil, errors.New("Unexpected closing parenthesis.")
-		}
-		state.nextOperation = expectEndOrOperator
-		return &currentRule, err
-	} else if strings.Contains(token, ":") {
-		assertion, err := parseComparison(token)
-		if err != nil {
-			return nil, err
-		}
-		state.addAssertion(assertion)
-		state.nextOperation = expectEndOrOperator
-		return nil, nil
+	if _, err := parseInterpolation(match); err != nil {
+		formatError := *err.(*PolicyError)
+		formatError.Expression = value
+		formatError.Column += offset
+		return nil, &formatError
 	}
-	errorMessage := fmt.Sprintf("Unexpected token: %v", token)
-	return nil, errors.New(errorMessage)
-}
 
-func expectEndOrOperator(token string, end bool, state *osloParserState) (*regoRule, error) {
-	if end {
-		rule, err := state.pop()
-		if err != nil {
-			return nil, err
-		}
-		if state.Len() != 0 {
-			return nil, errors.New("Unclosed subexpression")
-		}
-		return &rule, nil
-	} else if token == ")" {
-		currentRule, err := state.pop()
-		// We can't advance if we're in the base rule, it has to be a sub rule
-		if err != nil || state.Len() == 0 {
-			return nil, errors.New("Unexpected closing parenthesis.")
-		}
-		return &currentRule, err
-	} else if token == "and" {
-		state.nextOperation = expectStart
-		return nil, nil
-	} else if token == "or" {
-		currentRule, err := state.pop()
-		if err != nil {
-			return nil, err
+	if comparedValues[0] == "http" || comparedValues[0] == "https" {
+		return HTTPCheck{Kind: comparedValues[0], Match: comparedValues[1]}, nil
+	} else if comparedValues[0] == "role" {
+		return RoleCheck{Match: comparedValues[1]}, nil
+	}
+	return GenericCheck{Kind: comparedValues[0], Match: comparedValues[1]}, nil
+}
+
+// splitCheck splits a check into its left and right operands at the first
+// colon. If the left operand is a quoted literal, the colons inside of it are
+// part of it, e.g. "'a:b':%(name)s" is split into "'a:b'" and "%(name)s". A
+// missing operand is returned as an empty string.
+func splitCheck(value string) []string {
+	searchFrom := 0
+	if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
+		if closing := strings.IndexByte(value[1:], value[0]); closing >= 0 {
+			searchFrom = closing + 2
 		}
-		rule := newRule(currentRule)
-		state.push(rule)
-		state.nextOperation = expectStart
-		return &currentRule, nil
 	}
-	errorMessage := fmt.Sprintf("Unexpected token: %v", token)
-	return nil, errors.New(errorMessage)
-}
-
-func runeIsWhitespace(r rune) bool {
-	return r == ' ' || r == '\t'
-}
-
-func tokenize(value string) (string, string) {
-	var parsedIndex int
-	var whitespaceOffset int
-	var prevChar rune
-	var parsingToken bool
-
-	for index, char := range value {
-		// If we'ere not parsing a token we return the start parenthesis as a
-		// token
-		if char == '(' && !parsingToken {
-			unparsedStart := index + 1
-			return "(", value[unparsedStart:]
+	if index := strings.Index(value[searchFrom:], ":"); index >= 0 {
+		return []string{value[:searchFrom+index], value[searchFrom+index+1:]}
+	}
+	return []string{value, ""}
+}
+
+// targetValueIsReference tells if the given value references a value from the
+// target, e.g. "%(target.secret.project_id)s"
+func targetValueIsReference(value string) bool {
+	return strings.HasPrefix(value, "%(") && strings.HasSuffix(value, ")s") &&
+		strings.Index(value, ")") == len(value)-2
+}
+
+// interpolationPart is a piece of a value that references values from the
+// target with the python format "%(name)s": either literal text, or the name
+// of a value of the target.
+type interpolationPart struct {
+	text      string
+	reference bool
+}
+
+// parseInterpolation splits the given value into its literal text and its
+// references to the target, e.g. "id-%(target.id)s" into the text "id-" and
+// the reference to "target.id". "%%" is a literal "%", and any other format
+// specifier is an error, whose column is the position of the specifier in the
+// value.
+func parseInterpolation(value string) ([]interpolationPart, error) {
+	var parts []interpolationPart
+	var literal strings.Builder
+
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		if char != '%' {
+			literal.WriteByte(char)
+			continue
 		}
-		if runeIsWhitespace(char) {
-			// If we're parsing a token, a whitespace is a token delimiter.
-			if parsingToken && prevChar == ')' {
-				// If the previous character was a closing parenthesis, and we
-				// encounter a whitespace delimiter, return the token we were
-				// parsing, and start the next parsing at the end parenthesis
-				parsedEnd := index - 1
-				unparsedStart := index - 1
-				return value[:parsedEnd], value[unparsedStart:]
-			} else if parsingToken {
-				parsedEnd := index
-				unparsedStart := index + 1
-				return value[whitespaceOffset:parsedEnd], value[unparsedStart:]
-			} else {
-				// ignore whitespaces otherwise
-				parsedIndex = index
-				prevChar = char
-				whitespaceOffset = whitespaceOffset + 1
-				continue
-			}
+		rest := value[index+1:]
+		if strings.HasPrefix(rest, "%") {
+			literal.WriteByte('%')
+			index++
+			continue
 		}
-		if char == ')' && prevChar == ')' {
-			if parsingToken {
-				parsedEnd := index - 1
-				unparsedStart := index - 1
-				return value[:parsedEnd], value[unparsedStart:]
-			} else {
-				parsedEnd := index
-				unparsedStart := index - 1
-				return value[:parsedEnd], value[unparsedStart:]
-			}
+		column := utf8.RuneCountInString(value[:index]) + 1
+		end := strings.Index(rest, ")")
+		if !strings.HasPrefix(rest, "(") {
+			errorMessage := fmt.Sprintf("Unsupported format specifier %%%s in value %v, "+
+				"only %%(name)s is supported", firstRune(rest), value)
+			return nil, &PolicyError{Kind: ErrorUnsupportedFormat, Expression: value, Column: column,
+				Message: errorMessage}
+		} else if end == -1 {
+			errorMessage := fmt.Sprintf("Unmatched parentheses in value %v", value)
+			return nil, &PolicyError{Kind: ErrorUnsupportedFormat, Expression: value, Column: column,
+				Message: errorMessage}
+		} else if !strings.HasPrefix(rest[end+1:], "s") {
+			errorMessage := fmt.Sprintf("Unsupported format specifier %%%s%s in value %v, "+
+				"only %%(name)s is supported", rest[:end+1], firstRune(rest[end+1:]), value)
+			return nil, &PolicyError{Kind: ErrorUnsupportedFormat, Expression: value, Column: column,
+				Message: errorMessage}
 		}
-		if char == ')' && !parsingToken {
-			unparsedStart := index + 1
-			return ")", value[unparsedStart:]
+		if literal.Len() > 0 {
+			parts = append(parts, interpolationPart{text: literal.String()})
+			literal.Reset()
 		}
-		parsedIndex = index
-		parsingToken = true
-		prevChar = char
-	}
-	if prevChar == ')' {
-		// If the previous character was a closing parenthesis, and we
-		// encounter the end, return the token we were
-		// parsing, and start the next parsing at the end parenthesis
-		parsedEnd := parsedIndex
-		return value[:parsedEnd], value[parsedEnd:]
+		parts = append(parts, interpolationPart{text: rest[1:end], reference: true})
+		index += end + 2
 	}
-	if len(value) > 0 {
-		parsedEnd := parsedIndex + 1
-		return value[:parsedEnd], value[parsedEnd:]
+
+	if literal.Len() > 0 {
+		parts = append(parts, interpolationPart{text: literal.String()})
 	}
-	return "", ""
+	return parts, nil
 }
 
-// parseYamlOrJSON takes a given string and parses it into a string map of
-// interfaces. The given string is meant to be an oslo.policy read as an input.
-func parseYamlOrJSON(input string) (map[string]interface{}, error) {
-	var output map[string]interface{}
+// parseYamlOrJSON takes a given string and parses it into an ordered map of
+// interfaces, which keeps the keys in the order they were written. The given
+// string is meant to be an oslo.policy read as an input.
+func parseYamlOrJSON(input string) (yaml.MapSlice, error) {
+	var output yaml.MapSlice
 	err := yaml.Unmarshal([]byte(input), &output)
 	if err != nil {
-		return nil, err
+		return nil, &PolicyError{Kind: ErrorInvalidDocument, Message: err.Error(), Err: err}
 	}
 	return output, nil
 }
@@ -522,38 +436,71 @@ func validatePackageName(packageName string) bool {
 	return false
 }
 
+// ParseCheck parses a single oslo.policy expression (e.g.
+// "role:admin or rule:owner") into its tree of checks.
+func ParseCheck(rule string) (Check, error) {
+	if rule == "" {
+		return TrueCheck{}, nil
+	}
+
+	parser := checkParser{expression: rule, tokens: tokenize(rule)}
+	check, err := parser.parseExpression()
+	if err != nil {
+		parser.errors = append(parser.errors, err.(*PolicyError))
+	} else if trailing := parser.peek(); trailing.kind == tokenRightParenthesis {
+		parser.errors = append(parser.errors, parser.errorAt(trailing.column, ErrorUnmatchedParenthesis, "Unexpected closing parenthesis"))
+	} else if trailing.kind != tokenEnd {
+		parser.errors = append(parser.errors, parser.errorAt(trailing.column, ErrorUnexpectedToken, "Expected \"and\" or \"or\" instead of "+trailing.String()))
+	}
+	if len(parser.errors) > 0 {
+		return nil, parser.errors
+	}
+	return check, nil
+}
+
+// ParsePolicy takes a yaml or JSON string containing oslo.policy rules and
+// parses them into a Policy, which can be inspected, modified and rendered
+// with RenderRego. If some of the rules can't be parsed, the error is a
+// PolicyErrors with all of their problems, and the returned policy has the
+// rest of them, the failed ones never holding. The policy is only nil if the
+// input isn't valid YAML or JSON.
+func ParsePolicy(input string) (*Policy, error) {
+	rules, err := parseYamlOrJSON(input)
+	if err != nil {
+		return nil, asPolicyErrors(err)
+	}
+	return parseRules(rules)
+}
+
 // OsloPolicy2Rego takes a yaml or JSON string containing oslo.policy rules and
-// converts them into Rego language.
+// converts them into Rego language. The rules are emitted in the order they
+// were given in the input.
 //
 // It takes the packageName as the first argument, which should be a string
 // that consists of ascii letters and numbers, with periods. This will
 // represent the name of the package in OPA, and will be persisted to the URL
 // that will be used for doing queries.
 //
-//     For example, if you pass "openstack.policy" as a packageName, the
-//     resulting package name will be "openstack.policy". Subsequently you'll
-//     be able to query: http://<OPA URL>/v1/data/openstack/policy/allow
-//
+//	For example, if you pass "openstack.policy" as a packageName, the
+//	resulting package name will be "openstack.policy". Subsequently you'll
+//	be able to query: http://<OPA URL>/v1/data/openstack/policy/allow
 func OsloPolicy2Rego(packageName, input string) (string, error) {
-	packageNameWorks := validatePackageName(packageName)
-
-	if !packageNameWorks {
-		errorMessage := fmt.Sprintf("The package name %s is invalid. "+
-			"It must consist of strings of letters and digits separated by "+
-			"dots ('.'). e.g. 'openstack.policy'", packageName)
-		return "", errors.New(errorMessage)
-	}
+	return OsloPolicy2RegoWithOptions(packageName, input, Options{})
+}
 
-	rules, err := parseYamlOrJSON(input)
-	if err != nil {
+// OsloPolicy2RegoWithOptions works like OsloPolicy2Rego, but the conversion
+// can be tweaked with the given options.
+func OsloPolicy2RegoWithOptions(packageName, input string, opts Options) (string, error) {
+	policy, err := ParsePolicy(input)
+	if policy == nil {
 		return "", err
 	}
-
-	op := osloParser{Package: packageName}
-	op.Init()
-	err = op.parseRules(rules)
-	if err != nil {
-		return "", err
+	// The rules that parsed are still rendered, to report their errors
+	// too.
+	output, renderErr := RenderRego(packageName, policy, opts)
+	errs := append(asPolicyErrors(err), asPolicyErrors(renderErr)...)
+	if len(errs) > 0 && !opts.PartialOutput {
+		return "", errs
 	}
-	return op.String(), nil
+	return output, errorOrNil(errs)
 }
@@ -1,12 +1,26 @@
 package oslopolicy2rego
 
 import (
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
 )
 
 // parseYamlOrJSON tests
 
+func mapSliceGet(m yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
 func TestParseYamlOrJSONParsesSimpleYamlCases(t *testing.T) {
 	test1yaml := `
 ---
@@ -23,7 +37,7 @@ b: 2`
 	for _, c := range cases {
 		got, _ := parseYamlOrJSON(c.input)
 		for wantedKey, wantedValue := range c.want {
-			gottenValue, ok := got[wantedKey]
+			gottenValue, ok := mapSliceGet(got, wantedKey)
 			if !ok || gottenValue != wantedValue {
 				t.Errorf("parseYamlOrJSON() with input:\n %s\n entry {%v -> %v} didn't match {%v -> %v}",
 					c.input, wantedKey, wantedValue, wantedKey, gottenValue)
@@ -67,7 +81,7 @@ func TestParseYamlOrJSONParsesSimpleJSONCases(t *testing.T) {
 	want := map[string]interface{}{"a": "1", "b": 2, "c": nil}
 	got, _ := parseYamlOrJSON(input)
 	for wantedKey, wantedValue := range want {
-		gottenValue, ok := got[wantedKey]
+		gottenValue, ok := mapSliceGet(got, wantedKey)
 		if !ok || gottenValue != wantedValue {
 			t.Errorf("parseYamlOrJSON() with input:\n %s\n entry {%v -> %v} didn't match {%v -> %v}",
 				input, wantedKey, wantedValue, wantedKey, gottenValue)
@@ -75,6 +89,27 @@ func TestParseYamlOrJSONParsesSimpleJSONCases(t *testing.T) {
 	}
 }
 
+func TestParseYamlOrJSONKeepsKeyOrder(t *testing.T) {
+	input := `
+{
+	"zeta": "1",
+	"alpha": "2",
+	"mu": "3"
+}`
+	want := []string{"zeta", "alpha", "mu"}
+	got, _ := parseYamlOrJSON(input)
+	if len(got) != len(want) {
+		t.Fatalf("parseYamlOrJSON() with input:\n %s\n should have returned %d keys, instead got: %v",
+			input, len(want), got)
+	}
+	for i, wantedKey := range want {
+		if got[i].Key != wantedKey {
+			t.Errorf("parseYamlOrJSON() with input:\n %s\n key #%d should be %s, instead got: %v",
+				input, i, wantedKey, got[i].Key)
+		}
+	}
+}
+
 func TestValidatePackageName(t *testing.T) {
 	cases := []struct {
 		input string
@@ -96,6 +131,266 @@ func TestValidatePackageName(t *testing.T) {
 	}
 }
 
+// ParseCheck tests
+
+func TestParseCheckBuildsTree(t *testing.T) {
+	cases := []struct {
+		input string
+		want  Check
+	}{
+		{"", TrueCheck{}},
+		{"@", TrueCheck{}},
+		{"!", FalseCheck{}},
+		{"role:admin", RoleCheck{Match: "admin"}},
+		{"rule:admin", RuleCheck{Match: "admin"}},
+		{"project_id:%(target.project_id)s", GenericCheck{Kind: "project_id", Match: "%(target.project_id)s"}},
+		{"not rule:admin", NotCheck{Check: RuleCheck{Match: "admin"}}},
+		{"rule:a and rule:b", AndCheck{Checks: []Check{RuleCheck{Match: "a"}, RuleCheck{Match: "b"}}}},
+		{"rule:a or rule:b and rule:c", OrCheck{Checks: []Check{
+			RuleCheck{Match: "a"},
+			AndCheck{Checks: []Check{RuleCheck{Match: "b"}, RuleCheck{Match: "c"}}},
+		}}},
+		{"not (rule:a or rule:b) and role:c", AndCheck{Checks: []Check{
+			NotCheck{Check: OrCheck{Checks: []Check{RuleCheck{Match: "a"}, RuleCheck{Match: "b"}}}},
+			RoleCheck{Match: "c"},
+		}}},
+		{"((rule:a))", RuleCheck{Match: "a"}},
+		{"rule:a and rule:b or rule:c and rule:d", OrCheck{Checks: []Check{
+			AndCheck{Checks: []Check{RuleCheck{Match: "a"}, RuleCheck{Match: "b"}}},
+			AndCheck{Checks: []Check{RuleCheck{Match: "c"}, RuleCheck{Match: "d"}}},
+		}}},
+		{"not rule:a and rule:b", AndCheck{Checks: []Check{
+			NotCheck{Check: RuleCheck{Match: "a"}},
+			RuleCheck{Match: "b"},
+		}}},
+		{"rule:a AND (rule:b OR rule:c)", AndCheck{Checks: []Check{
+			RuleCheck{Match: "a"},
+			OrCheck{Checks: []Check{RuleCheck{Match: "b"}, RuleCheck{Match: "c"}}},
+		}}},
+		{"rule:a\n  or rule:b", OrCheck{Checks: []Check{RuleCheck{Match: "a"}, RuleCheck{Match: "b"}}}},
+		{"'my role':%(target.name)s", GenericCheck{Kind: "'my role'", Match: "%(target.name)s"}},
+		{`(project:"a (b)")`, GenericCheck{Kind: "project", Match: `"a (b)"`}},
+		{"'a:b':%(name)s or rule:c", OrCheck{Checks: []Check{
+			GenericCheck{Kind: "'a:b'", Match: "%(name)s"},
+			RuleCheck{Match: "c"},
+		}}},
+		{"project:o'brien", GenericCheck{Kind: "project", Match: "o'brien"}},
+		{"@ and role:admin", AndCheck{Checks: []Check{TrueCheck{}, RoleCheck{Match: "admin"}}}},
+		{"! or rule:x", OrCheck{Checks: []Check{FalseCheck{}, RuleCheck{Match: "x"}}}},
+		{"not (@) and not !", AndCheck{Checks: []Check{NotCheck{Check: TrueCheck{}}, NotCheck{Check: FalseCheck{}}}}},
+	}
+	for _, c := range cases {
+		got, err := ParseCheck(c.input)
+		if err != nil {
+			t.Errorf("ParseCheck() with input: %s\nFailed with: %v", c.input, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseCheck() with input: %s\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, got)
+		}
+	}
+}
+
+func TestParseCheckErrorsPointAtTheColumn(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"rule:a or x", `Unexpected token "x", checks are written as kind:match at column 11:
+    rule:a or x
+              ^`},
+		{"rule:a or", `Unexpected end of expression at column 10:
+    rule:a or
+             ^`},
+		{"rule:a and (rule:b or rule:c", `Unclosed parenthesis at column 12:
+    rule:a and (rule:b or rule:c
+               ^`},
+		{"rule:a) or rule:b", `Unexpected closing parenthesis at column 7:
+    rule:a) or rule:b
+          ^`},
+		{"rule:a rule:b", `Expected "and" or "or" instead of "rule:b" at column 8:
+    rule:a rule:b
+           ^`},
+		{"(rule:a rule:b)", `Expected "and", "or" or ")" instead of "rule:b" at column 9:
+    (rule:a rule:b)
+            ^`},
+		{"rule:a and or rule:b", `Unexpected token "or" at column 12:
+    rule:a and or rule:b
+               ^`},
+		{"rule:a and ()", `Unexpected token ")" at column 13:
+    rule:a and ()
+                ^`},
+		{"rule:a or project:'my project", `Expected "and" or "or" instead of "project" at column 23:
+    rule:a or project:'my project
+                          ^`},
+		{"rule:a or\n:%(project)s", `You need to provide a left operand for the comparison: :%(project)s at column 11:
+    rule:a or :%(project)s
+              ^`},
+	}
+	for _, c := range cases {
+		got, err := ParseCheck(c.input)
+		if err == nil {
+			t.Errorf("ParseCheck() should have returned an error for: %q\nInstead got: %v", c.input, got)
+		} else if err.Error() != c.want {
+			t.Errorf("ParseCheck() error for: %q\nDidn't match:\n%s\nInstead got:\n%s", c.input, c.want, err)
+		}
+	}
+}
+
+func TestCheckStringRendersOsloSyntax(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"@", "@"},
+		{"!", "!"},
+		{"role:admin", "role:admin"},
+		{"not rule:admin", "not rule:admin"},
+		{"rule:a or rule:b and not rule:c", "(rule:a or (rule:b and not rule:c))"},
+		{"True:%(target.is_public)s", "True:%(target.is_public)s"},
+		{"(@ or !) and not @", "((@ or !) and not @)"},
+	}
+	for _, c := range cases {
+		check, err := ParseCheck(c.input)
+		if err != nil {
+			t.Errorf("ParseCheck() with input: %s\nFailed with: %v", c.input, err)
+		} else if check.String() != c.want {
+			t.Errorf("Check.String() with input: %s\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, check.String())
+		}
+	}
+}
+
+// The quoted literals round trip, as oslo.policy keeps the quotes in the
+// checks it parses. Whitespace inside of quotes (see TestTokenizeMatchesOslo)
+// and colons inside of a quoted kind are extensions, as oslo.policy splits the
+// check at its first colon.
+func TestParseCheckRoundTripsQuotedLiterals(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"'my role':%(target.name)s", "'my role':%(target.name)s"},
+		{`"my role":%(target.name)s`, `"my role":%(target.name)s`},
+		{"name:'a (b) or c' and rule:d", "(name:'a (b) or c' and rule:d)"},
+		{"(name:'x)' or not name:\"(y\")", "(name:'x)' or not name:\"(y\")"},
+	}
+	for _, c := range cases {
+		check, err := ParseCheck(c.input)
+		if err != nil {
+			t.Errorf("ParseCheck() with input: %s\nFailed with: %v", c.input, err)
+			continue
+		}
+		if check.String() != c.want {
+			t.Errorf("Check.String() with input: %s\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, check.String())
+		}
+		reparsed, err := ParseCheck(check.String())
+		if err != nil {
+			t.Errorf("ParseCheck() with input: %s\nFailed with: %v", check.String(), err)
+		} else if !reflect.DeepEqual(reparsed, check) {
+			t.Errorf("ParseCheck() with input: %s\nDidn't match %v\nInstead got: %v",
+				check.String(), check, reparsed)
+		}
+	}
+}
+
+// ParsePolicy tests
+
+func TestParsePolicy(t *testing.T) {
+	input := `
+{
+	"admin": "role:admin",
+	"secrets:get": "rule:admin or not role:reader"
+}`
+	policy, err := ParsePolicy(input)
+	if err != nil {
+		t.Fatalf("ParsePolicy() failed with: %v", err)
+	}
+	want := map[string]string{
+		"admin":       "role:admin",
+		"secrets:get": "(rule:admin or not role:reader)",
+	}
+	if len(policy.Rules) != len(want) {
+		t.Errorf("ParsePolicy() should have returned %d rules, instead got: %v", len(want), policy.Rules)
+	}
+	for _, rule := range policy.Rules {
+		if rule.Check.String() != want[rule.Name] {
+			t.Errorf("ParsePolicy() rule %s didn't match %s\nInstead got: %s",
+				rule.Name, want[rule.Name], rule.Check)
+		}
+		if rule.IsAction() != (rule.Name == "secrets:get") {
+			t.Errorf("Rule.IsAction() for rule %s returned %v", rule.Name, rule.IsAction())
+		}
+	}
+}
+
+func TestRenderRegoRendersModifiedPolicy(t *testing.T) {
+	policy, err := ParsePolicy(`{"admin": "role:admin", "secrets:get": "rule:admin"}`)
+	if err != nil {
+		t.Fatalf("ParsePolicy() failed with: %v", err)
+	}
+	policy.Rules[1].Check = AndCheck{Checks: []Check{
+		policy.Rules[1].Check,
+		RoleCheck{Match: "reader"},
+	}}
+	got, err := RenderRego("openstack.policy", policy, Options{})
+	if err != nil {
+		t.Fatalf("RenderRego() failed with: %v", err)
+	}
+	want := `secrets_get {
+    admin
+    lower(credentials.roles[_]) = "reader"
+}`
+	if !strings.Contains(got, want) {
+		t.Errorf("RenderRego() didn't contain:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestOsloPolicy2RegoUndefinedReferencesNeverHold(t *testing.T) {
+	got, err := OsloPolicy2Rego("openstack.policy", `{"foo_bar": "@", "x:y": "rule:foo-bar"}`)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	want := `x_y {
+    false
+}`
+	if !strings.Contains(got, want) {
+		t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestRenderRegoRendersEmptyGroups(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{Name: "a", Check: AndCheck{}},
+		{Name: "b", Check: OrCheck{}},
+		{Name: "c", Check: AndCheck{Checks: []Check{RoleCheck{Match: "reader"}, OrCheck{}}}},
+		{Name: "d", Check: NotCheck{Check: AndCheck{}}},
+	}}
+	got, err := RenderRego("openstack.policy", policy, Options{})
+	if err != nil {
+		t.Fatalf("RenderRego() failed with: %v", err)
+	}
+	subRule := subRuleName(subRulePrefix, "c", OrCheck{}.String())
+	want := []string{`a {
+    true
+}`, `b {
+    false
+}`, `c {
+    lower(credentials.roles[_]) = "reader"
+    ` + subRule + `
+}
+` + subRule + ` {
+    false
+}`, `d {
+    false
+}`}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("RenderRego() didn't contain:\n%s\nGot:\n%s", w, got)
+		}
+	}
+}
+
 // OsloPolicy2Rego tests
 
 func TestOsloPolicy2RegoSuccesses(t *testing.T) {
@@ -114,28 +409,29 @@ default allow = false`
 	"secrets:get": "rule:admin"
 }`
 	oneRuleOneActionOutput := []string{`admin {
-    credentials.roles[_] = "admin"
+    lower(credentials.roles[_]) = "admin"
+}`, `secrets_get {
+    admin
 }`, `allow {
     rule = "secrets:get"
-    admin
+    secrets_get
 }`}
 
 	alwaysFalseInput := `
 {
 	"secrets:get": "!"
 }`
-	alwaysFalseOutput := []string{`allow {
-    rule = "secrets:get"
+	alwaysFalseOutput := []string{`secrets_get {
     false
 }`}
 
 	notStatementInput := `
 {
+	"admin": "role:admin",
 	"secrets:get": "not rule:admin"
 }
 `
-	notStatementOutput := []string{`allow {
-    rule = "secrets:get"
+	notStatementOutput := []string{`secrets_get {
     not admin
 }`}
 
@@ -143,8 +439,7 @@ default allow = false`
 {
 	"secrets:get": ""
 }`
-	alwaysTrue := []string{`allow {
-    rule = "secrets:get"
+	alwaysTrue := []string{`secrets_get {
     true
 }`}
 
@@ -160,12 +455,12 @@ default allow = false`
 
 	multipleAssertionsWithAndInput := `
 {
+	"admin": "role:admin", "creator": "role:creator", "reader": "role:reader", "audit": "role:audit",
 	"secrets:get": "rule:admin and rule:creator and rule:reader and not rule:audit"
 }
 `
 
-	multipleAssertionsWithAndOutput := []string{`allow {
-    rule = "secrets:get"
+	multipleAssertionsWithAndOutput := []string{`secrets_get {
     admin
     creator
     reader
@@ -174,18 +469,16 @@ default allow = false`
 
 	multipleRulesWithOrInput := `
 {
+	"admin": "role:admin", "creator": "role:creator", "reader": "role:reader",
 	"secrets:get": "rule:admin or rule:creator or rule:reader"
 }
 `
 
-	multipleRulesWithOrOutput := []string{`allow {
-    rule = "secrets:get"
+	multipleRulesWithOrOutput := []string{`secrets_get {
     admin
-}`, `allow {
-    rule = "secrets:get"
+}`, `secrets_get {
     creator
-}`, `allow {
-    rule = "secrets:get"
+}`, `secrets_get {
     reader
 }`}
 
@@ -195,7 +488,7 @@ default allow = false`
 }
 `
 	credentialsTargetComparisonOutput := []string{`secret_project_match {
-    credentials.project = target.target.secret.project_id
+    openstack_str(openstack_values(credentials.project)[_]) = openstack_str(target.target.secret.project_id)
 }`}
 
 	literalStringValueComparisonInput := `
@@ -204,7 +497,7 @@ default allow = false`
 }
 `
 	stringValueComparisonOutput := []string{`secret_project_match {
-    credentials.project = "asdf"
+    openstack_str(openstack_values(credentials.project)[_]) = "asdf"
 }`}
 
 	leftSideQuotedStringValueComparisonInput := `
@@ -229,7 +522,7 @@ default allow = false`
 `
 
 	numberValueComparisonOutput := []string{`secret_project_match {
-    credentials.project = 123
+    openstack_str(openstack_values(credentials.project)[_]) = "123"
 }`}
 
 	leftSideBooleanTrueValueComparisonInput := `
@@ -244,7 +537,7 @@ default allow = false`
 `
 
 	booleanTrueValueComparisonOutput := []string{`secret_project_match {
-    credentials.project = true
+    openstack_str(openstack_values(credentials.project)[_]) = "True"
 }`}
 
 	leftSideBooleanFalseValueComparisonInput := `
@@ -259,7 +552,22 @@ default allow = false`
 `
 
 	booleanFalseValueComparisonOutput := []string{`secret_project_match {
-    credentials.project = false
+    openstack_str(openstack_values(credentials.project)[_]) = "False"
+}`}
+
+	// A constant on the left is compared with the match as a string, like
+	// oslo.policy does, so the match isn't looked up in the credentials.
+	leftSideQuotedStringValueComparisonOutput := []string{`secret_project_match {
+    "asdf" = "project"
+}`}
+	leftSideNumberValueComparisonOutput := []string{`secret_project_match {
+    "123" = "project"
+}`}
+	leftSideBooleanTrueValueComparisonOutput := []string{`secret_project_match {
+    "True" = "project"
+}`}
+	leftSideBooleanFalseValueComparisonOutput := []string{`secret_project_match {
+    "False" = "project"
 }`}
 
 	constantTargetComparisonInput := `
@@ -268,67 +576,171 @@ default allow = false`
 }
 `
 	constantTargetComparisonOutput := []string{`secret_project_match {
-    false = target.target.secret.project_id
+    "False" = openstack_str(target.target.secret.project_id)
 }`}
 
 	simpleParenthesesInput := `
 {
+	"creator": "role:creator", "reader": "role:reader",
 	"secrets:get": "False:%(target.secret.project_id)s or (rule:creator and rule:reader)"
 }
 `
 
-	simpleParenthesesOutput := []string{`allow {
-    rule = "secrets:get"
-    false = target.target.secret.project_id`, ` {
+	simpleParenthesesOutput := []string{`secrets_get {
+    "False" = openstack_str(target.target.secret.project_id)
+}`, `secrets_get {
     creator
     reader
 }`}
 
 	multipleParenthesesInput := `
 {
+	"admin": "role:admin", "creator": "role:creator", "reader": "role:reader", "foo": "role:foo", "bar": "role:bar",
 	"secrets:get": "rule:admin or (rule:creator and rule:reader) or not (rule:foo and rule:bar)"
 }
 `
 
-	multipleParenthesesOutput := []string{`allow {
-    rule = "secrets:get"
-    admin`, ` {
+	multipleParenthesesOutput := []string{`secrets_get {
+    admin
+}`, `secrets_get {
     creator
     reader
-}`, ` {
-    foo
-    bar
+}`, `secrets_get {
+    not foo
+}`, `secrets_get {
+    not bar
 }`}
 
 	nestedParenthesesInput1 := `
 {
+	"creator": "role:creator", "reader": "role:reader", "foo": "role:foo", "bar": "role:bar",
 	"secrets:get": "(rule:creator and rule:reader) or not ((rule:foo and rule:bar))"
 }
 `
 
-	nestedParenthesesOutput1 := []string{`allow {
-    rule = "secrets:get"
-    openstack_rule`, ` {
+	nestedParenthesesOutput1 := []string{`secrets_get {
     creator
     reader
-}`, ` {
-    foo
-    bar
+}`, `secrets_get {
+    not foo
+}`, `secrets_get {
+    not bar
 }`}
 
 	nestedParenthesesInput2 := `
 {
+	"foo": "role:foo", "bar": "role:bar",
 	"secrets:get": "((rule:foo and rule:bar))"
 }
 `
 
-	nestedParenthesesOutput2 := []string{`allow {
-    rule = "secrets:get"
-    openstack_rule`, ` {
+	nestedParenthesesOutput2 := []string{`secrets_get {
     foo
     bar
 }`}
 
+	dottedPathsComparisonInput := `
+{
+	"domain_match": "token.project.domain.id:%(target.project.domain-id)s"
+}
+`
+
+	dottedPathsComparisonOutput := []string{`domain_match {
+    openstack_str(openstack_values(openstack_values(openstack_values(openstack_values(credentials.token)[_].project)[_].domain)[_].id)[_]) = openstack_str(target.target.project["domain-id"])
+}`}
+
+	invalidIdentifiersComparisonInput := `
+{
+	"is_admin": "is-admin:True",
+	"is_default": "default:%(not)s"
+}
+`
+
+	invalidIdentifiersComparisonOutput := []string{`is_admin {
+    openstack_str(openstack_values(credentials["is-admin"])[_]) = "True"
+}`, `is_default {
+    openstack_str(openstack_values(credentials["default"])[_]) = openstack_str(target["not"])
+}`}
+
+	listOfListsInput := `
+{
+	"secrets:get": [["role:admin"], ["project_id:%(project_id)s", "role:member"]]
+}
+`
+
+	listOfListsOutput := []string{`secrets_get {
+    lower(credentials.roles[_]) = "admin"
+}`, `secrets_get {
+    openstack_str(openstack_values(credentials.project_id)[_]) = openstack_str(target.project_id)
+    lower(credentials.roles[_]) = "member"
+}`}
+
+	listOfListsWithStringItemInput := `
+{
+	"owner": "role:owner",
+	"secrets:get": ["role:admin", ["role:member", "rule:owner"]]
+}
+`
+
+	listOfListsWithStringItemOutput := []string{`secrets_get {
+    lower(credentials.roles[_]) = "admin"
+}`, `secrets_get {
+    lower(credentials.roles[_]) = "member"
+    owner
+}`}
+
+	listOfListsWithEmptyStringInput := `
+{
+	"secrets:get": [["role:admin"], ""]
+}
+`
+
+	listOfListsWithEmptyStringOutput := []string{`secrets_get {
+    lower(credentials.roles[_]) = "admin"
+}`}
+
+	listOfListsWithSpecialChecksInput := `
+secrets:get:
+  - ["@"]
+  - ["!", "role:admin"]
+`
+
+	listOfListsWithSpecialChecksOutput := []string{`secrets_get {
+    true
+}`, `secrets_get {
+    false
+    lower(credentials.roles[_]) = "admin"
+}`}
+
+	listOfEmptyListsInput := `
+{
+	"secrets:get": [[], []]
+}
+`
+
+	httpCheckInput := `
+{
+	"secrets:get": "http://policy.example.com/check"
+}
+`
+
+	httpCheckOutput := []string{`secrets_get {
+    trim(http.send({"method": "POST", "url": "http://policy.example.com/check", ` +
+		`"headers": {"Content-Type": "application/x-www-form-urlencoded"}, ` +
+		`"raw_body": urlquery.encode_object({"rule": json.marshal(rule), ` +
+		`"target": json.marshal(target), "credentials": json.marshal(credentials)})}).raw_body, "\"") = "True"
+}`}
+
+	httpsCheckWithTargetInput := `
+{
+	"secrets:get": "role:admin or https://policy.example.com/%(project_id)s/100%%"
+}
+`
+
+	httpsCheckWithTargetOutput := []string{`secrets_get {
+    trim(http.send({"method": "POST", ` +
+		`"url": sprintf("https://policy.example.com/%v/100%%", [openstack_str(target.project_id)]), `}
+
 	cases := []struct {
 		description string
 		input       string
@@ -344,19 +756,28 @@ default allow = false`
 		{"Should add multiple rules with the 'or' keyword", multipleRulesWithOrInput, multipleRulesWithOrOutput},
 		{"Should render comparison between incoming credentials and target", credentialsTargetComparisonInput, credentialsTargetComparisonOutput},
 		{"Should render comparison between incoming credentials and string", literalStringValueComparisonInput, stringValueComparisonOutput},
-		{"Should render comparison between incoming credentials and quoted string on the left", leftSideQuotedStringValueComparisonInput, stringValueComparisonOutput},
+		{"Should compare a quoted string on the left with the match", leftSideQuotedStringValueComparisonInput, leftSideQuotedStringValueComparisonOutput},
 		{"Should render comparison between incoming credentials and quoted string on the right", rightSideQuotedStringValueComparisonInput, stringValueComparisonOutput},
-		{"Should render comparison between incoming credentials and number on the left", leftSideNumberValueComparisonInput, numberValueComparisonOutput},
+		{"Should compare a number on the left with the match", leftSideNumberValueComparisonInput, leftSideNumberValueComparisonOutput},
 		{"Should render comparison between incoming credentials and number on the right", rightSideNumberValueComparisonInput, numberValueComparisonOutput},
-		{"Should render comparison between incoming credentials and true boolean value on the left", leftSideBooleanTrueValueComparisonInput, booleanTrueValueComparisonOutput},
+		{"Should compare a true boolean value on the left with the match", leftSideBooleanTrueValueComparisonInput, leftSideBooleanTrueValueComparisonOutput},
 		{"Should render comparison between incoming credentials and true boolean value on the right", rightSideBooleanTrueValueComparisonInput, booleanTrueValueComparisonOutput},
-		{"Should render comparison between incoming credentials and false boolean value on the left", leftSideBooleanFalseValueComparisonInput, booleanFalseValueComparisonOutput},
+		{"Should compare a false boolean value on the left with the match", leftSideBooleanFalseValueComparisonInput, leftSideBooleanFalseValueComparisonOutput},
 		{"Should render comparison between incoming credentials and false boolean value on the right", rightSideBooleanFalseValueComparisonInput, booleanFalseValueComparisonOutput},
 		{"Should render comparison between constant and target", constantTargetComparisonInput, constantTargetComparisonOutput},
 		{"Should render parentheses expression (one level)", simpleParenthesesInput, simpleParenthesesOutput},
 		{"Should render multiple parentheses expression", multipleParenthesesInput, multipleParenthesesOutput},
 		{"Should render nested parentheses expression #1", nestedParenthesesInput1, nestedParenthesesOutput1},
 		{"Should render nested parentheses expression #2", nestedParenthesesInput2, nestedParenthesesOutput2},
+		{"Should render dotted paths as nested references", dottedPathsComparisonInput, dottedPathsComparisonOutput},
+		{"Should render keys that aren't identifiers with brackets", invalidIdentifiersComparisonInput, invalidIdentifiersComparisonOutput},
+		{"Should render legacy list of lists as or of ands", listOfListsInput, listOfListsOutput},
+		{"Should render http check as http.send", httpCheckInput, httpCheckOutput},
+		{"Should render https check with target values in the URL", httpsCheckWithTargetInput, httpsCheckWithTargetOutput},
+		{"Should render strings in legacy list of lists as single checks", listOfListsWithStringItemInput, listOfListsWithStringItemOutput},
+		{"Should skip empty strings in legacy list of lists", listOfListsWithEmptyStringInput, listOfListsWithEmptyStringOutput},
+		{"Should render special checks in legacy list of lists", listOfListsWithSpecialChecksInput, listOfListsWithSpecialChecksOutput},
+		{"Action should always be false given a list of empty lists", listOfEmptyListsInput, alwaysFalseOutput},
 	}
 	for _, c := range cases {
 		got, err := OsloPolicy2Rego("openstack.policy", c.input)
@@ -378,6 +799,714 @@ default allow = false`
 	}
 }
 
+func TestOsloPolicy2RegoSubRuleNamesAreDeterministic(t *testing.T) {
+	input := `
+{
+	"admin": "role:admin", "foo": "role:foo", "bar": "role:bar", "a": "role:a", "b": "role:b", "c": "role:c",
+	"secrets:get": "rule:admin or not (rule:foo and rule:bar) or (rule:a or rule:b) and rule:c"
+}`
+	first, err := OsloPolicy2Rego("openstack.policy", input)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		got, _ := OsloPolicy2Rego("openstack.policy", input)
+		if got != first {
+			t.Fatalf("OsloPolicy2Rego() output changed between runs:\n%s\nThen got:\n%s", first, got)
+		}
+	}
+	want := `secrets_get {
+    openstack_rule_b63440dee2ca
+    c
+}`
+	if !strings.Contains(first, want) {
+		t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", want, first)
+	}
+}
+
+func TestOsloPolicy2RegoReusesSubRulesForTheSameCheck(t *testing.T) {
+	input := `
+{
+	"secrets:get": "(rule:a or rule:b) and role:x or (rule:a or rule:b) and role:y"
+}`
+	got, err := OsloPolicy2Rego("openstack.policy", input)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	name := subRuleName("openstack_rule", "secrets_get", "(rule:a or rule:b)")
+	if count := strings.Count(got, name+" {"); count != 2 {
+		t.Errorf("OsloPolicy2Rego() should have defined %s twice (once per alternative), "+
+			"instead it did %d times:\n%s", name, count, got)
+	}
+	if count := strings.Count(got, "    "+name+"\n"); count != 2 {
+		t.Errorf("OsloPolicy2Rego() should have referenced %s twice, instead it did %d times:\n%s",
+			name, count, got)
+	}
+}
+
+func TestOsloPolicy2RegoKeepsSourceOrder(t *testing.T) {
+	input := `
+zeta: role:z
+secrets:list: rule:zeta
+alpha: role:a
+secrets:get: rule:alpha
+`
+	cases := []struct {
+		description string
+		opts        Options
+		want        []string
+	}{
+		{"Rules should be emitted in source order", Options{},
+			[]string{"zeta {", `rule = "secrets:list"`, "alpha {", `rule = "secrets:get"`}},
+		{"Rules should be emitted sorted by name", Options{SortRules: true},
+			[]string{"alpha {", `rule = "secrets:get"`, `rule = "secrets:list"`, "zeta {"}},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, c.opts)
+		if err != nil {
+			t.Fatalf("OsloPolicy2RegoWithOptions() test case \"%s\" failed with: %v", c.description, err)
+		}
+		lastIndex := -1
+		for _, wantedOutput := range c.want {
+			index := strings.Index(got, wantedOutput)
+			if index <= lastIndex {
+				t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" should have emitted %v in order, got:\n%s",
+					c.description, c.want, got)
+				break
+			}
+			lastIndex = index
+		}
+	}
+}
+
+func TestOsloPolicy2RegoHTTPCheckModes(t *testing.T) {
+	input := `
+{
+	"secrets:get": "role:admin or http://policy.example.com/%(id)s/100%%"
+}`
+	got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, Options{HTTPChecks: HTTPCheckError})
+	if err == nil {
+		t.Errorf("OsloPolicy2RegoWithOptions() should have returned an error for HTTP checks, "+
+			"instead got:\n%s", got)
+	}
+
+	got, err = OsloPolicy2RegoWithOptions("openstack.policy", input, Options{HTTPChecks: HTTPCheckData})
+	if err != nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed with: %v", err)
+	}
+	want := `secrets_get {
+    data.http_checks[sprintf("http://policy.example.com/%v/100%%", [openstack_str(target.id)])] = true
+}`
+	if !strings.Contains(got, want) {
+		t.Errorf("OsloPolicy2RegoWithOptions() didn't contain:\n%s\nGot:\n%s", want, got)
+	}
+
+	got, err = OsloPolicy2RegoWithOptions("openstack.policy", `{"a:b": "http://example.com/100%%"}`,
+		Options{HTTPChecks: HTTPCheckData})
+	if err != nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed with: %v", err)
+	}
+	want = `data.http_checks["http://example.com/100%"] = true`
+	if !strings.Contains(got, want) {
+		t.Errorf("OsloPolicy2RegoWithOptions() didn't contain:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestOsloPolicy2RegoRoleMatching(t *testing.T) {
+	input := `
+{
+	"secrets:get": "role:Admin"
+}`
+	cases := []struct {
+		description string
+		opts        Options
+		want        string
+	}{
+		{"Roles should be compared ignoring the case", Options{},
+			`lower(credentials.roles[_]) = "admin"`},
+		{"Roles should be compared as given with strict matching", Options{StrictRoleMatching: true},
+			`credentials.roles[_] = "Admin"`},
+		{"Roles should be looked up with in ignoring the case in rego v1", Options{RegoVersion: RegoV1},
+			`"admin" in [lower(role) | some role in credentials.roles]`},
+		{"Roles should be looked up with in as given in rego v1 with strict matching",
+			Options{RegoVersion: RegoV1, StrictRoleMatching: true},
+			`"Admin" in credentials.roles`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, c.opts)
+		if err != nil {
+			t.Fatalf("OsloPolicy2RegoWithOptions() test case \"%s\" failed with: %v", c.description, err)
+		}
+		if !strings.Contains(got, "    "+c.want+"\n") {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" didn't contain:\n%s\nGot:\n%s",
+				c.description, c.want, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoRegoV1(t *testing.T) {
+	input := `
+{
+	"default": "role:member",
+	"owner": "project_id:%(project_id)s",
+	"secrets:get": "role:admin or rule:owner and not http://x/%(id)s"
+}`
+	want := `
+package openstack.policy
+
+import rego.v1
+
+import input.credentials as credentials
+import input.rule as rule
+import input.target as target
+
+default allow := false
+# oslo.policy rule "default"
+rule_default if {
+    "member" in [lower(role) | some role in credentials.roles]
+}
+owner if {
+    openstack_str(openstack_values(credentials.project_id)[_]) == openstack_str(target.project_id)
+}
+# oslo.policy rule "secrets:get"
+secrets_get if {
+    "admin" in [lower(role) | some role in credentials.roles]
+}
+secrets_get if {
+    owner
+    not data.http_checks[sprintf("http://x/%v", [openstack_str(target.id)])] == true
+}
+allow if {
+    rule == "secrets:get"
+    secrets_get
+}
+allow if {
+    not rule in actions
+    rule_default
+}
+openstack_str(value) := value if {
+    is_string(value)
+}
+openstack_str(value) := sprintf("%v", [value]) if {
+    is_number(value)
+}
+openstack_str(value) := "True" if {
+    value == true
+}
+openstack_str(value) := "False" if {
+    value == false
+}
+openstack_str(value) := "None" if {
+    is_null(value)
+}
+openstack_values(value) := value if {
+    is_array(value)
+}
+openstack_values(value) := [value] if {
+    not is_array(value)
+}
+actions := {"secrets:get"}
+`
+	got, err := OsloPolicy2RegoWithOptions("openstack.policy", input,
+		Options{RegoVersion: RegoV1, HTTPChecks: HTTPCheckData})
+	if err != nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed with: %v", err)
+	}
+	if got != want {
+		t.Errorf("OsloPolicy2RegoWithOptions() didn't match:\n%s\nInstead got:\n%s", want, got)
+	}
+}
+
+func TestOsloPolicy2RegoInputLayout(t *testing.T) {
+	input := `{"secrets:get": "project_id:%(project_id)s"}`
+	cases := []struct {
+		description string
+		layout      InputLayout
+		want        string
+	}{
+		{"The default layout", InputLayout{}, `
+import input.credentials as credentials
+import input.rule as rule
+import input.target as target
+`},
+		{"A custom layout", InputLayout{Credentials: "token", Action: "action", Target: "resource"}, `
+import input.token as credentials
+import input.action as rule
+import input.resource as target
+`},
+		{"A partial layout with nested paths", InputLayout{Credentials: "request.auth.token"}, `
+import input.request.auth.token as credentials
+import input.rule as rule
+import input.target as target
+`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, Options{Input: c.layout})
+		if err != nil {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" failed with: %v", c.description, err)
+		} else if !strings.Contains(got, c.want) {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" didn't contain:\n%s\nGot:\n%s",
+				c.description, c.want, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoInvalidInputLayout(t *testing.T) {
+	for _, layout := range []InputLayout{
+		{Credentials: "x-auth-token"},
+		{Action: "request..action"},
+		{Target: "input.not"},
+		{Target: "resource\n} allow { true"},
+	} {
+		got, err := OsloPolicy2RegoWithOptions("openstack.policy", `{"a": "@"}`, Options{Input: layout})
+		if err == nil {
+			t.Errorf("OsloPolicy2RegoWithOptions() should have failed with the layout: %+v\nInstead got:\n%s",
+				layout, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoRoleFromTarget(t *testing.T) {
+	input := `
+{
+	"identity:create_grant": "role:%(target.role.name)s"
+}`
+	cases := []struct {
+		description string
+		opts        Options
+		want        string
+	}{
+		{"Role from the target should be compared ignoring the case", Options{},
+			`lower(credentials.roles[_]) = lower(openstack_str(target.target.role.name))`},
+		{"Role from the target should be compared as given with strict matching", Options{StrictRoleMatching: true},
+			`credentials.roles[_] = openstack_str(target.target.role.name)`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2RegoWithOptions("openstack.policy", input, c.opts)
+		if err != nil {
+			t.Fatalf("OsloPolicy2RegoWithOptions() test case \"%s\" failed with: %v", c.description, err)
+		}
+		if !strings.Contains(got, "    "+c.want+"\n") {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" didn't contain:\n%s\nGot:\n%s",
+				c.description, c.want, got)
+		}
+	}
+}
+
+func TestRenderReference(t *testing.T) {
+	cases := []struct {
+		root string
+		path string
+		want string
+	}{
+		{"credentials", "project_id", "credentials.project_id"},
+		{"credentials", "token.project.domain.id", "credentials.token.project.domain.id"},
+		{"target", "target.project.domain_id", "target.target.project.domain_id"},
+		{"credentials", "is-admin", `credentials["is-admin"]`},
+		{"credentials", "token.default.id", `credentials.token["default"].id`},
+		{"target", "not.in", `target["not"]["in"]`},
+		{"credentials", "2fa", `credentials["2fa"]`},
+		{"credentials", "a..b", `credentials.a[""].b`},
+	}
+	for _, c := range cases {
+		got := renderReference(c.root, c.path)
+		if got != c.want {
+			t.Errorf("renderReference() with input: %s, %s\nDidn't match %v\nInstead got: %v",
+				c.root, c.path, c.want, got)
+		}
+	}
+}
+
+func TestRenderStringEscapesLiterals(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"admin", `"admin"`},
+		{`ad"min`, `"ad\"min"`},
+		{`ad\min`, `"ad\\min"`},
+		{"ad\nmin\t", `"ad\nmin\t"`},
+		{"\x00\x1b\x7f", `"\u0000\u001b\u007f"`},
+		{"r\u00f4le", "\"r\u00f4le\""},
+		{"\xff", `"\ufffd"`},
+	}
+	for _, c := range cases {
+		got := renderString(c.input)
+		if got != c.want {
+			t.Errorf("renderString() with input: %q\nDidn't match %v\nInstead got: %v",
+				c.input, c.want, got)
+		}
+		if unquoted, err := strconv.Unquote(got); err != nil || (utf8.ValidString(c.input) && unquoted != c.input) {
+			t.Errorf("renderString() with input: %q\nDidn't render a valid string literal: %v", c.input, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoHostileLiteralsCantBreakOut(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		want        string
+	}{
+		{"Quote in role", `{"a": "role:x\"}\u0000allow{true"}`,
+			`lower(credentials.roles[_]) = "x\"}\u0000allow{true"`},
+		{"Quote in quoted literal", `{"a": "project:'x\"}allow{true'"}`,
+			`openstack_str(openstack_values(credentials.project)[_]) = "x\"}allow{true"`},
+		{"Backslash at the end of a literal", `{"a": "project:x\\"}`,
+			`openstack_str(openstack_values(credentials.project)[_]) = "x\\"`},
+		{"Quote in credentials key", `{"a": "a\"b:x"}`,
+			`openstack_str(openstack_values(credentials["a\"b"])[_]) = "x"`},
+		{"Quote in action name", `{"secrets:get\" } allow { true": "!"}`,
+			`rule = "secrets:get\" } allow { true"`},
+		{"Quote in http check", `{"a:b": "http://x/\"%(id)s"}`,
+			`"url": sprintf("http://x/\"%v", [openstack_str(target.id)])`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2Rego("openstack.policy", c.input)
+		if err != nil {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" failed with: %v", c.description, err)
+			continue
+		}
+		if !strings.Contains(got, c.want) {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" didn't contain:\n%s\nGot:\n%s",
+				c.description, c.want, got)
+		}
+		// No hostile content may end up starting a line of its own
+		for _, line := range strings.Split(got, "\n") {
+			if strings.TrimSpace(line) == "true" || strings.HasPrefix(line, "allow { true") {
+				t.Errorf("OsloPolicy2Rego() test case \"%s\" broke out of the literal:\n%s",
+					c.description, got)
+			}
+		}
+	}
+}
+
+func TestOsloPolicy2RegoQuotedLiterals(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{`{"a": "'my role':%(target.name)s"}`, `"my role" = openstack_str(target.target.name)`},
+		// oslo.policy only keeps the quotes of matches quoted with double
+		// quotes.
+		{`{"a": "project:\"a (b)\" or role:c"}`,
+			`openstack_str(openstack_values(credentials.project)[_]) = "\"a (b)\""`},
+		{`{"a": "project:'it''s'"}`, `openstack_str(openstack_values(credentials.project)[_]) = "it''s"`},
+		// Quotes that aren't closed are ordinary characters
+		{`{"a": "role:'admin"}`, `lower(credentials.roles[_]) = "'admin"`},
+		{`{"a": "name:'s-team"}`, `openstack_str(openstack_values(credentials.name)[_]) = "'s-team"`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2Rego("openstack.policy", c.input)
+		if err != nil {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nFailed with: %v", c.input, err)
+		} else if !strings.Contains(got, c.want) {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nDidn't contain:\n%s\nGot:\n%s", c.input, c.want, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoInterpolatedMatches(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{`{"a": "project:project-%(project_id)s"}`,
+			`openstack_str(openstack_values(credentials.project)[_]) = sprintf("project-%v", [openstack_str(target.project_id)])`},
+		{`{"a": "path:'%(a)s/%(b)s'"}`,
+			`openstack_str(openstack_values(credentials.path)[_]) = sprintf("%v/%v", [openstack_str(target.a), openstack_str(target.b)])`},
+		{`{"a": "'100%% sure':%(a)s%%"}`,
+			`"100%% sure" = sprintf("%v%%", [openstack_str(target.a)])`},
+		{`{"a": "name:50%%"}`,
+			`openstack_str(openstack_values(credentials.name)[_]) = "50%"`},
+		{`{"a": "role:%(domain)s_admin"}`,
+			`lower(credentials.roles[_]) = lower(sprintf("%v_admin", [openstack_str(target.domain)]))`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2Rego("openstack.policy", c.input)
+		if err != nil {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nFailed with: %v", c.input, err)
+		} else if !strings.Contains(got, c.want) {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nDidn't contain:\n%s\nGot:\n%s", c.input, c.want, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoUnsupportedFormats(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{`{"a": "project:%(project_id)d"}`,
+			`Error in key a: "Unsupported format specifier %(project_id)d in value %(project_id)d, only %(name)s is supported at column 9:
+    project:%(project_id)d
+            ^"`},
+		{`{"a": "project:p-%s"}`,
+			`Error in key a: "Unsupported format specifier %s in value p-%s, only %(name)s is supported at column 11:
+    project:p-%s
+              ^"`},
+		{`{"a": "project:50%"}`,
+			`Error in key a: "Unsupported format specifier % in value 50%, only %(name)s is supported at column 11:
+    project:50%
+              ^"`},
+		{`{"a": "project:p-%(project_id"}`,
+			`Error in key a: "Unmatched parentheses in value p-%(project_id at column 11:
+    project:p-%(project_id
+              ^"`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2Rego("openstack.policy", c.input)
+		if err == nil {
+			t.Errorf("OsloPolicy2Rego() should have failed with input: %s\nInstead got:\n%s", c.input, got)
+		} else if err.Error() != c.want {
+			t.Errorf("OsloPolicy2Rego() with input: %s\nDidn't fail with:\n%s\nInstead got:\n%v", c.input, c.want, err)
+		}
+	}
+}
+
+// oslo.policy compares the credential value, converted with str(), with the
+// match formatted with the target, and looks into the items of the lists it
+// finds along the credential path.
+func TestOsloPolicy2RegoComparisonSemantics(t *testing.T) {
+	cases := []struct {
+		description string
+		check       string
+		want        string
+	}{
+		{"Credential and target values are compared as strings",
+			"project_id:%(project_id)s",
+			`openstack_str(openstack_values(credentials.project_id)[_]) = openstack_str(target.project_id)`},
+		{"A boolean match is the string python gives for it",
+			"is_admin:True",
+			`openstack_str(openstack_values(credentials.is_admin)[_]) = "True"`},
+		{"A number match is compared as a string",
+			"level:10",
+			`openstack_str(openstack_values(credentials.level)[_]) = "10"`},
+		{"A constant kind is converted the way python's str() does",
+			"0x10:%(level)s",
+			`"16" = openstack_str(target.level)`},
+		{"A float kind is formatted like python does",
+			"-1.50:%(level)s",
+			`"-1.5" = openstack_str(target.level)`},
+		{"A None kind is the string None",
+			"None:%(parent_id)s",
+			`"None" = openstack_str(target.parent_id)`},
+		{"A null credential matches None",
+			"parent_id:None",
+			`openstack_str(openstack_values(credentials.parent_id)[_]) = "None"`},
+		{"A constant kind is compared with the literal match",
+			"True:is_admin",
+			`"True" = "is_admin"`},
+		{"Every list along the credential path is looked into",
+			"groups.id:%(group_id)s",
+			`openstack_str(openstack_values(openstack_values(credentials.groups)[_].id)[_]) = openstack_str(target.group_id)`},
+		{"Interpolated target values are converted as strings",
+			"name:%(a)s-%(b)s",
+			`openstack_str(openstack_values(credentials.name)[_]) = sprintf("%v-%v", [openstack_str(target.a), openstack_str(target.b)])`},
+	}
+	for _, c := range cases {
+		input := `{"a": ` + strconv.Quote(c.check) + `}`
+		got, err := OsloPolicy2Rego("openstack.policy", input)
+		if err != nil {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" failed with: %v", c.description, err)
+			continue
+		}
+		want := "a {\n    " + c.want + "\n}"
+		if !strings.Contains(got, want) {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" didn't contain:\n%s\nGot:\n%s",
+				c.description, want, got)
+		}
+		if strings.Contains(c.want, "openstack_str(") && !strings.Contains(got, "openstack_values(value) = [value] {") {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" didn't define the conversion functions:\n%s",
+				c.description, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoConversionsOnlyWhenNeeded(t *testing.T) {
+	got, err := OsloPolicy2Rego("openstack.policy", `{"a": "role:admin or rule:b", "b": "!"}`)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	if strings.Contains(got, "openstack_str") {
+		t.Errorf("OsloPolicy2Rego() shouldn't define the conversion functions:\n%s", got)
+	}
+}
+
+func TestOsloPolicy2RegoTrueAndFalseOperands(t *testing.T) {
+	input := `{"a": "@ and role:admin", "b": "! or rule:a", "c": "not @"}`
+	want := []string{`a {
+    true
+    lower(credentials.roles[_]) = "admin"
+}`, `b {
+    false
+}
+b {
+    a
+}`, `c {
+    false
+}`}
+	got, err := OsloPolicy2Rego("openstack.policy", input)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	for _, rule := range want {
+		if !strings.Contains(got, rule) {
+			t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", rule, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoMangledRuleNames(t *testing.T) {
+	input := `
+{
+	"admin-or-owner": "role:admin or project_id:%(project_id)s",
+	"default": "rule:admin-or-owner",
+	"secrets:get": "rule:default"
+}`
+	want := []string{`# oslo.policy rule "admin-or-owner"
+admin_or_owner {
+    lower(credentials.roles[_]) = "admin"
+}`, `admin_or_owner {
+    openstack_str(openstack_values(credentials.project_id)[_]) = openstack_str(target.project_id)
+}`, `# oslo.policy rule "default"
+rule_default {
+    admin_or_owner
+}`, `secrets_get {
+    rule_default
+}`}
+	got, err := OsloPolicy2Rego("openstack.policy", input)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	for _, wantedOutput := range want {
+		if !strings.Contains(got, wantedOutput) {
+			t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", wantedOutput, got)
+		}
+	}
+	if strings.Count(got, "# oslo.policy rule") != 3 {
+		t.Errorf("OsloPolicy2Rego() should only comment the first definition of the renamed rules:\n%s", got)
+	}
+}
+
+func TestOsloPolicy2RegoActionsCanBeReferenced(t *testing.T) {
+	input := `
+{
+	"identity:get_user": "role:admin or user_id:%(target.user.id)s",
+	"identity:update_user": "rule:identity:get_user and role:manager"
+}`
+	want := []string{`# oslo.policy rule "identity:get_user"
+identity_get_user {
+    lower(credentials.roles[_]) = "admin"
+}
+identity_get_user {
+    openstack_str(openstack_values(credentials.user_id)[_]) = openstack_str(target.target.user.id)
+}
+allow {
+    rule = "identity:get_user"
+    identity_get_user
+}`, `# oslo.policy rule "identity:update_user"
+identity_update_user {
+    identity_get_user
+    lower(credentials.roles[_]) = "manager"
+}
+allow {
+    rule = "identity:update_user"
+    identity_update_user
+}`}
+	got, err := OsloPolicy2Rego("openstack.policy", input)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	for _, wantedOutput := range want {
+		if !strings.Contains(got, wantedOutput) {
+			t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", wantedOutput, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoDefaultRuleFallback(t *testing.T) {
+	input := `
+{
+	"default": "role:admin",
+	"secrets:get": "rule:default or role:reader",
+	"secrets:list": "role:reader"
+}`
+	want := []string{`# oslo.policy rule "default"
+rule_default {
+    lower(credentials.roles[_]) = "admin"
+}`, `secrets_get {
+    rule_default
+}`, `allow {
+    not actions[rule]
+    rule_default
+}`, `actions = {"secrets:get", "secrets:list"}
+`}
+	got, err := OsloPolicy2Rego("openstack.policy", input)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	for _, wantedOutput := range want {
+		if !strings.Contains(got, wantedOutput) {
+			t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", wantedOutput, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoNoDefaultRule(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		want        string
+	}{
+		{"Actions should be listed without a default rule", `{"secrets:get": "role:reader"}`,
+			`actions = {"secrets:get"}`},
+		{"Empty set of actions should be valid rego", `{"admin": "role:admin"}`,
+			`actions = set()`},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2Rego("openstack.policy", c.input)
+		if err != nil {
+			t.Fatalf("OsloPolicy2Rego() test case \"%s\" failed with: %v", c.description, err)
+		}
+		if !strings.Contains(got, c.want) {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" didn't contain:\n%s\nGot:\n%s",
+				c.description, c.want, got)
+		}
+		if strings.Contains(got, "not actions[rule]") {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" shouldn't fall back without a default rule:\n%s",
+				c.description, got)
+		}
+	}
+}
+
+func TestParsePolicyRepeatedKeyKeepsLastValue(t *testing.T) {
+	input := `
+{
+	"a": "role:first",
+	"b": "role:b",
+	"a": "role:last"
+}`
+	policy, err := ParsePolicy(input)
+	if err != nil {
+		t.Fatalf("ParsePolicy() failed with: %v", err)
+	}
+	if len(policy.Rules) != 2 || policy.Rules[0].Name != "a" || policy.Rules[0].Check.String() != "role:last" {
+		t.Errorf("ParsePolicy() should have kept the last value of \"a\" in its first position, got: %v",
+			policy.Rules)
+	}
+}
+
+func TestParsePolicyRepeatedKeyIgnoresEarlierErrors(t *testing.T) {
+	policy, err := ParsePolicy("a: \"((\"\na: \"@\"")
+	if err != nil {
+		t.Fatalf("ParsePolicy() failed with: %v", err)
+	}
+	if len(policy.Rules) != 1 || policy.Rules[0].Check != (TrueCheck{}) {
+		t.Errorf("ParsePolicy() should have kept the last value of \"a\", got: %v", policy.Rules)
+	}
+}
+
 func TestOsloPolicy2RegoErrors(t *testing.T) {
 	wrongInput := `
 {
@@ -389,6 +1518,26 @@ func TestOsloPolicy2RegoErrors(t *testing.T) {
 	"secrets:get": [1, 2, 3]
 }`
 
+	listOfListsWithItems := `
+{
+	"secrets:get": [[1, 2], ["role:admin"]]
+}`
+
+	listOfListsWithNestedList := `
+{
+	"secrets:get": [[["role:admin"]]]
+}`
+
+	httpCheckWithUnsupportedFormat := `
+{
+	"secrets:get": "http://example.com/%d"
+}`
+
+	roleFromTargetWithUnmatchedParentheses := `
+{
+	"identity:create_grant": "role:%(target.role.name"
+}`
+
 	numericValue := `
 {
 	"secrets:get": 1
@@ -469,6 +1618,10 @@ func TestOsloPolicy2RegoErrors(t *testing.T) {
 	}{
 		{"Invalidly formatted input should fail", wrongInput},
 		{"List with items should fail", listWithItems},
+		{"Role from the target with unmatched parentheses should fail", roleFromTargetWithUnmatchedParentheses},
+		{"HTTP check with an unsupported format should fail", httpCheckWithUnsupportedFormat},
+		{"List of lists with non-string items should fail", listOfListsWithItems},
+		{"List of lists with nested lists should fail", listOfListsWithNestedList},
 		{"Numeric value should fail", numericValue},
 		{"Empty map should fail", emptyMap},
 		{"Nested map should fail", nestedMap},
@@ -0,0 +1,146 @@
+package oslopolicy2rego
+
+import (
+	"strings"
+)
+
+// Policy is the parsed representation of an oslo.policy file. It holds the
+// rules that were defined in it.
+type Policy struct {
+	Rules []Rule
+	// The keys that were defined more than once in the document.
+	duplicateKeys []string
+	// The expressions that the rules were parsed from, indexed by key, so
+	// that the errors found when rendering them can point at their checks.
+	expressions map[string]string
+}
+
+// Rule is a named oslo.policy entry. Names that contain a colon (e.g.
+// "secrets:get") are actions, every other name is an alias that can be
+// referenced from other rules with the "rule:" check.
+type Rule struct {
+	Name  string
+	Check Check
+}
+
+// Check is a node of the expression tree of an oslo.policy rule. Calling
+// String() on it gives back the rule in oslo.policy syntax.
+type Check interface {
+	String() string
+	isCheck()
+}
+
+// AndCheck holds if all of its sub-checks hold.
+type AndCheck struct {
+	Checks []Check
+}
+
+// OrCheck holds if any of its sub-checks hold.
+type OrCheck struct {
+	Checks []Check
+}
+
+// NotCheck holds if its sub-check doesn't.
+type NotCheck struct {
+	Check Check
+}
+
+// RoleCheck holds if the credentials contain the given role, e.g.
+// "role:admin".
+type RoleCheck struct {
+	Match string
+}
+
+// RuleCheck holds if the referenced rule holds, e.g. "rule:admin".
+type RuleCheck struct {
+	Match string
+}
+
+// GenericCheck compares the Kind, which is a value from the credentials or a
+// constant, with the Match, which is a constant or a value from the target.
+// e.g. "project_id:%(target.project_id)s" or "True:%(target.is_public)s"
+type GenericCheck struct {
+	Kind  string
+	Match string
+}
+
+// HTTPCheck holds if the remote server at the URL answers "True" when the
+// target and credentials are posted to it, e.g.
+// "http://policy.example.com/check/%(target.project_id)s". The Kind is the
+// scheme of the URL ("http" or "https") and the Match is the rest of it.
+type HTTPCheck struct {
+	Kind  string
+	Match string
+}
+
+// URL returns the URL of the check, which may contain references to the
+// target.
+func (c HTTPCheck) URL() string {
+	return c.Kind + ":" + c.Match
+}
+
+// TrueCheck always holds. It's written as "@", "" or [] in oslo.policy.
+type TrueCheck struct{}
+
+// FalseCheck never holds. It's written as "!" in oslo.policy.
+type FalseCheck struct{}
+
+// IsAction tells if the rule is an action (e.g. "secrets:get") rather than an
+// alias that other rules refer to.
+func (r Rule) IsAction() bool {
+	return strings.Contains(r.Name, ":")
+}
+
+func joinChecks(checks []Check, operator string) string {
+	var output []string
+	for _, check := range checks {
+		output = append(output, check.String())
+	}
+	return "(" + strings.Join(output, " "+operator+" ") + ")"
+}
+
+func (c AndCheck) String() string {
+	return joinChecks(c.Checks, "and")
+}
+
+func (c OrCheck) String() string {
+	return joinChecks(c.Checks, "or")
+}
+
+func (c NotCheck) String() string {
+	return "not " + c.Check.String()
+}
+
+func (c RoleCheck) String() string {
+	return "role:" + c.Match
+}
+
+func (c RuleCheck) String() string {
+	return "rule:" + c.Match
+}
+
+func (c GenericCheck) String() string {
+	return c.Kind + ":" + c.Match
+}
+
+func (c HTTPCheck) String() string {
+	return c.URL()
+}
+
+func (c TrueCheck) String() string {
+	return "@"
+}
+
+func (c FalseCheck) String() string {
+	return "!"
+}
+
+func (AndCheck) isCheck()     {}
+func (OrCheck) isCheck()      {}
+func (NotCheck) isCheck()     {}
+func (RoleCheck) isCheck()    {}
+func (RuleCheck) isCheck()    {}
+func (GenericCheck) isCheck() {}
+func (HTTPCheck) isCheck()    {}
+func (TrueCheck) isCheck()    {}
+func (FalseCheck) isCheck()   {}
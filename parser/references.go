@@ -0,0 +1,134 @@
+package oslopolicy2rego
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReferenceErrorKind tells what's wrong with a "rule:" reference.
+type ReferenceErrorKind int
+
+const (
+	// ReferenceMissing means the referenced rule isn't defined.
+	ReferenceMissing ReferenceErrorKind = iota
+	// ReferenceCycle means the rule ends up referencing itself.
+	ReferenceCycle
+)
+
+// ReferenceError reports a "rule:" check that can't be resolved. The Chain
+// holds the keys of the rules involved, in the order they reference each
+// other. For a missing reference it ends with the undefined name, e.g.
+// ["secrets:get", "admin", "missing"], and for a cycle it starts and ends
+// with the same key, e.g. ["a", "b", "a"].
+type ReferenceError struct {
+	Kind  ReferenceErrorKind
+	Chain []string
+}
+
+func (e ReferenceError) Error() string {
+	chain := strings.Join(e.Chain, " -> ")
+	if e.Kind == ReferenceCycle {
+		return fmt.Sprintf("Reference cycle: %s", chain)
+	}
+	return fmt.Sprintf("Reference to the undefined rule %s: %s", e.Chain[len(e.Chain)-1], chain)
+}
+
+// CheckReferences resolves the "rule:" checks of the given policy, and
+// returns the references to undefined rules and the reference cycles that
+// were found. The rules are walked in the order they were given, and every
+// problem is reported once. A reference to an undefined rule refers to the
+// default rule instead if there's one, so it can also be part of a cycle,
+// e.g. ["default", "a", "default"] for {"default": "rule:a", "a": "rule:b"}. Rego doesn't allow recursive rules, nor
+// references to undefined ones, so these need to be fixed for the generated
+// policy to work.
+func CheckReferences(policy *Policy) []ReferenceError {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	rules := make(map[string]Check)
+	for _, rule := range policy.Rules {
+		rules[rule.Name] = rule.Check
+	}
+
+	var referenceErrors []ReferenceError
+	// The references that were already reported, indexed by the name of the
+	// referencing rule and the referenced name.
+	reported := make(map[[2]string]bool)
+	states := make(map[string]int)
+	var stack []string
+
+	var visit func(name string)
+	visit = func(name string) {
+		states[name] = visiting
+		stack = append(stack, name)
+
+		for _, reference := range ruleReferences(rules[name]) {
+			edge := [2]string{name, reference}
+			if reported[edge] {
+				continue
+			}
+			if _, ok := rules[reference]; !ok {
+				reported[edge] = true
+				chain := append(append([]string(nil), stack...), reference)
+				referenceErrors = append(referenceErrors, ReferenceError{Kind: ReferenceMissing, Chain: chain})
+				// Like in oslo.policy, the default rule is checked
+				// instead of the undefined one, if there's one.
+				if _, ok := rules["default"]; !ok {
+					continue
+				}
+				reference = "default"
+			}
+			switch states[reference] {
+			case visiting:
+				reported[edge] = true
+				var chain []string
+				for index := len(stack) - 1; index >= 0; index-- {
+					if stack[index] == reference {
+						chain = append(append(chain, stack[index:]...), reference)
+						break
+					}
+				}
+				referenceErrors = append(referenceErrors, ReferenceError{Kind: ReferenceCycle, Chain: chain})
+			case unvisited:
+				visit(reference)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		states[name] = visited
+	}
+
+	for _, rule := range policy.Rules {
+		if states[rule.Name] == unvisited {
+			visit(rule.Name)
+		}
+	}
+	return referenceErrors
+}
+
+// ruleReferences returns the names of the rules that the given check
+// references with "rule:" checks.
+func ruleReferences(check Check) []string {
+	switch typedCheck := check.(type) {
+	case AndCheck:
+		var names []string
+		for _, subCheck := range typedCheck.Checks {
+			names = append(names, ruleReferences(subCheck)...)
+		}
+		return names
+	case OrCheck:
+		var names []string
+		for _, subCheck := range typedCheck.Checks {
+			names = append(names, ruleReferences(subCheck)...)
+		}
+		return names
+	case NotCheck:
+		return ruleReferences(typedCheck.Check)
+	case RuleCheck:
+		return []string{typedCheck.Match}
+	}
+	return nil
+}
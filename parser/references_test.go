@@ -0,0 +1,183 @@
+package oslopolicy2rego
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckReferences(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		want        []ReferenceError
+	}{
+		{"Defined references should resolve", `
+{
+	"admin": "role:admin",
+	"owner": "user_id:%(user_id)s",
+	"secrets:get": "rule:admin or rule:owner",
+	"secrets:list": "rule:secrets:get"
+}`, nil},
+		{"Missing reference should be reported with the chain", `
+{
+	"secrets:get": "rule:admin",
+	"admin": "role:admin or rule:missing"
+}`, []ReferenceError{
+			{Kind: ReferenceMissing, Chain: []string{"secrets:get", "admin", "missing"}},
+		}},
+		{"Missing reference should be reported once per referencing rule", `
+{
+	"a": "rule:missing or not rule:missing",
+	"b": "rule:missing"
+}`, []ReferenceError{
+			{Kind: ReferenceMissing, Chain: []string{"a", "missing"}},
+			{Kind: ReferenceMissing, Chain: []string{"b", "missing"}},
+		}},
+		{"Cycle should be reported with the chain", `
+{
+	"secrets:get": "rule:a",
+	"a": "rule:b",
+	"b": "role:admin and rule:c",
+	"c": "rule:a"
+}`, []ReferenceError{
+			{Kind: ReferenceCycle, Chain: []string{"a", "b", "c", "a"}},
+		}},
+		{"Missing reference should lead to the default rule", `
+{
+	"default": "rule:a",
+	"a": "rule:missing",
+	"b": "rule:other"
+}`, []ReferenceError{
+			{Kind: ReferenceMissing, Chain: []string{"default", "a", "missing"}},
+			{Kind: ReferenceCycle, Chain: []string{"default", "a", "default"}},
+			{Kind: ReferenceMissing, Chain: []string{"b", "other"}},
+		}},
+		{"Self reference should be reported as a cycle", `
+{
+	"a": "rule:a"
+}`, []ReferenceError{
+			{Kind: ReferenceCycle, Chain: []string{"a", "a"}},
+		}},
+	}
+	for _, c := range cases {
+		policy, err := ParsePolicy(c.input)
+		if err != nil {
+			t.Fatalf("ParsePolicy() test case \"%s\" failed with: %v", c.description, err)
+		}
+		got := CheckReferences(policy)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("CheckReferences() test case \"%s\" didn't match %v\nInstead got: %v",
+				c.description, c.want, got)
+		}
+	}
+}
+
+func TestReferenceErrorMessages(t *testing.T) {
+	cases := []struct {
+		input ReferenceError
+		want  string
+	}{
+		{ReferenceError{Kind: ReferenceMissing, Chain: []string{"secrets:get", "admin", "missing"}},
+			"Reference to the undefined rule missing: secrets:get -> admin -> missing"},
+		{ReferenceError{Kind: ReferenceCycle, Chain: []string{"a", "b", "a"}},
+			"Reference cycle: a -> b -> a"},
+	}
+	for _, c := range cases {
+		got := c.input.Error()
+		if got != c.want {
+			t.Errorf("ReferenceError.Error() didn't match %v\nInstead got: %v", c.want, got)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoStrictReferences(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		wantError   bool
+	}{
+		{"Defined references should work", `{"admin": "role:admin", "a:b": "rule:admin"}`, false},
+		{"Missing references should fail", `{"a:b": "rule:admin"}`, true},
+		{"Reference cycles should fail", `{"a": "rule:b", "b": "rule:a"}`, true},
+	}
+	for _, c := range cases {
+		got, err := OsloPolicy2RegoWithOptions("openstack.policy", c.input, Options{StrictReferences: true})
+		if c.wantError && err == nil {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" should have returned an error, "+
+				"instead got:\n%s", c.description, got)
+		} else if !c.wantError && err != nil {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" failed with: %v", c.description, err)
+		}
+		_, err = OsloPolicy2Rego("openstack.policy", c.input)
+		if err != nil {
+			t.Errorf("OsloPolicy2Rego() test case \"%s\" shouldn't fail without strict references: %v",
+				c.description, err)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoBreaksReferenceCycles(t *testing.T) {
+	var warnings []Warning
+	opts := Options{Warnings: func(warning Warning) {
+		warnings = append(warnings, warning)
+	}}
+	got, err := OsloPolicy2RegoWithOptions("openstack.policy", `{"a": "rule:b or role:a", "b": "rule:a"}`, opts)
+	if err != nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed with: %v", err)
+	}
+	want := []string{`a {
+    b
+}`, `b {
+    false
+}`}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("OsloPolicy2RegoWithOptions() didn't contain:\n%s\nGot:\n%s", w, got)
+		}
+	}
+	if len(warnings) != 1 || warnings[0].Code != WarningInvalidReference || warnings[0].Key != "b" {
+		t.Errorf("OsloPolicy2RegoWithOptions() should have warned about the reference from b, got: %v",
+			warnings)
+	}
+}
+
+func TestOsloPolicy2RegoUndefinedReferencesCheckTheDefaultRule(t *testing.T) {
+	var warnings []Warning
+	opts := Options{Warnings: func(warning Warning) {
+		warnings = append(warnings, warning)
+	}}
+	got, err := OsloPolicy2RegoWithOptions("openstack.policy",
+		`{"default": "role:admin", "x:y": "rule:missing", "a": "rule:missing2 or role:a"}`, opts)
+	if err != nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed with: %v", err)
+	}
+	want := []string{`x_y {
+    rule_default
+}`, `a {
+    rule_default
+}`}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("OsloPolicy2RegoWithOptions() didn't contain:\n%s\nGot:\n%s", w, got)
+		}
+	}
+	wantMessage := "Reference to the undefined rule missing: x:y -> missing, so the default rule is checked instead"
+	if len(warnings) != 2 || warnings[0].Message != wantMessage {
+		t.Errorf("OsloPolicy2RegoWithOptions() should have warned about the references to the default rule, "+
+			"got: %v", warnings)
+	}
+}
+
+func TestOsloPolicy2RegoBreaksCyclesThroughTheDefaultRule(t *testing.T) {
+	got, err := OsloPolicy2Rego("openstack.policy", `{"default": "rule:a", "a": "rule:missing or role:a"}`)
+	if err != nil {
+		t.Fatalf("OsloPolicy2Rego() failed with: %v", err)
+	}
+	want := `a {
+    false
+}`
+	if !strings.Contains(got, want) {
+		t.Errorf("OsloPolicy2Rego() didn't contain:\n%s\nGot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,847 @@
+package oslopolicy2rego
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// The templates render the syntax of the rego version given in the options:
+// "v1" tells if it's RegoV1, and "assign" and "equals" give its assignment and
+// comparison operators.
+const policyHeaderTemplate = `
+package {{.Package}}
+{{if v1}}
+import rego.v1
+{{end}}
+import input.{{.Options.Input.Credentials}} as credentials
+import input.{{.Options.Input.Action}} as rule
+import input.{{.Options.Input.Target}} as target
+
+default allow {{assign}} false
+`
+
+const actionTemplate = `allow {{if v1}}if {{end}}{
+    rule {{equals}} {{quote .Name}}
+    {{.Expression}}
+}`
+
+// When the requested rule isn't one of the actions of the policy, the
+// "default" rule decides, like in oslo.policy.
+const defaultTemplate = `allow {{if v1}}if {{end}}{
+    {{if v1}}not rule in actions{{else}}not actions[rule]{{end}}
+    {{.Expression}}
+}`
+
+const actionsTemplate = `actions {{assign}} {{if .Actions}}{ {{- range $index, $action := .Actions}}
+{{- if $index}}, {{end}}{{quote $action}}{{end -}} }{{else}}set(){{end}}`
+
+// The functions that reproduce how oslo.policy converts the values it
+// compares. openstack_str gives what python's str() would give for a JSON
+// value (lists and objects aside), and openstack_values gives the items of a
+// list, or a list of the value itself if it isn't one.
+const conversionsTemplate = `openstack_str(value) {{assign}} value {{if v1}}if {{end}}{
+    is_string(value)
+}
+openstack_str(value) {{assign}} sprintf("%v", [value]) {{if v1}}if {{end}}{
+    is_number(value)
+}
+openstack_str(value) {{assign}} "True" {{if v1}}if {{end}}{
+    value {{equals}} true
+}
+openstack_str(value) {{assign}} "False" {{if v1}}if {{end}}{
+    value {{equals}} false
+}
+openstack_str(value) {{assign}} "None" {{if v1}}if {{end}}{
+    is_null(value)
+}
+openstack_values(value) {{assign}} value {{if v1}}if {{end}}{
+    is_array(value)
+}
+openstack_values(value) {{assign}} [value] {{if v1}}if {{end}}{
+    not is_array(value)
+}`
+
+const aliasTemplate = `{{if .Comment}}# {{.Comment}}
+{{end}}{{.Name}} {{if v1}}if {{end}}{
+    {{.Expression}}
+}`
+
+// The data document that HTTP checks are looked up in when they are rendered
+// with HTTPCheckData.
+const httpChecksDataPath = "data.http_checks"
+
+// HTTPCheckMode tells how the "http:" and "https:" checks are converted.
+type HTTPCheckMode int
+
+const (
+	// HTTPCheckSend converts them into http.send calls, which post the
+	// target and the credentials to the remote server like oslo.policy
+	// does.
+	HTTPCheckSend HTTPCheckMode = iota
+	// HTTPCheckError makes the conversion fail if the policy contains any
+	// of them.
+	HTTPCheckError
+	// HTTPCheckData converts them into a lookup of the check's URL in the
+	// "http_checks" data document, e.g.
+	// data.http_checks["http://example.com/check"], which has to be true for
+	// the check to hold. This can be backed by a JSON document or by another
+	// rego policy.
+	HTTPCheckData
+)
+
+// RegoVersion tells which version of the rego syntax is generated.
+type RegoVersion int
+
+const (
+	// RegoV0 generates the syntax that OPA used before 1.0, e.g.
+	// "allow { ... }" and "default allow = false".
+	RegoV0 RegoVersion = iota
+	// RegoV1 generates the syntax of OPA 1.0, with "import rego.v1" so
+	// that older versions accept it too. Rules are written with "if",
+	// values are assigned with ":=" and compared with "==", and the roles
+	// are looked up with "in".
+	RegoV1
+)
+
+// InputLayout tells where the policy finds the values it looks at in the input
+// document, as dotted paths relative to it, e.g. "token" for input.token or
+// "request.token" for input.request.token. Empty paths take the defaults.
+type InputLayout struct {
+	// Credentials is the path of the credentials of the request, by
+	// default "credentials".
+	Credentials string
+	// Action is the path of the name of the rule being checked (e.g.
+	// "secrets:get"), by default "rule".
+	Action string
+	// Target is the path of the target of the request, by default
+	// "target".
+	Target string
+}
+
+// withDefaults returns the layout with the empty paths set to the defaults.
+func (l InputLayout) withDefaults() InputLayout {
+	if l.Credentials == "" {
+		l.Credentials = "credentials"
+	}
+	if l.Action == "" {
+		l.Action = "rule"
+	}
+	if l.Target == "" {
+		l.Target = "target"
+	}
+	return l
+}
+
+// Options tweak how a policy is converted into Rego. The zero value gives the
+// default behaviour.
+type Options struct {
+	// SortRules emits the rules sorted alphabetically by name, instead of in
+	// the order they were given in the policy.
+	SortRules bool
+	// HTTPChecks tells how "http:" and "https:" checks are converted.
+	HTTPChecks HTTPCheckMode
+	// StrictRoleMatching compares the roles case-sensitively. By default
+	// they are compared ignoring the case, like oslo.policy does.
+	StrictRoleMatching bool
+	// StrictReferences makes the conversion fail if a "rule:" check refers
+	// to an undefined rule, or if there is a reference cycle. Otherwise
+	// they are reported as warnings. Like in oslo.policy, a reference to an
+	// undefined rule checks the default rule instead, and never holds if
+	// there's none. The reference that closes a cycle never holds. They can
+	// also be found with CheckReferences.
+	StrictReferences bool
+	// RegoVersion tells which version of the rego syntax is generated.
+	RegoVersion RegoVersion
+	// Input tells where the credentials, the name of the rule being
+	// checked and the target are in the input document.
+	Input InputLayout
+	// PartialOutput returns the generated policy along with the errors if
+	// some of the rules fail, instead of no policy at all. The rules that
+	// failed never hold in it.
+	PartialOutput bool
+	// Warnings is called with each part of the policy whose conversion may
+	// behave differently than oslo.policy, if it's set.
+	Warnings func(Warning)
+	// SuppressWarnings lists the codes of the warnings that aren't
+	// reported.
+	SuppressWarnings []WarningCode
+}
+
+type expression struct {
+	assertions []string
+}
+
+type regoRule struct {
+	RuleType   string
+	Name       string
+	Comment    string
+	Expression expression
+}
+
+// This contains the actual list of rules
+type regoRules []regoRule
+
+// Wrapper struct to write the template
+type regoRenderer struct {
+	Package string
+	Rules   regoRules
+	Actions []string
+	Tmpl    *template.Template
+	Options Options
+	// Maps the names of the rules in the policy to the names they get in
+	// rego.
+	ruleNames map[string]string
+	// Keeps the checks that the generated sub-rules stand for, indexed by
+	// the name of the sub-rule.
+	subRules map[string]string
+	// Tells if the rendered rules call the conversion functions, which then
+	// need to be part of the policy.
+	usesConversions bool
+	// The key of the rule being rendered, which the warnings refer to.
+	key string
+	// The references that close a reference cycle, indexed by the key of
+	// the rule that has them and the referenced name.
+	cycleReferences map[[2]string]bool
+}
+
+func (e expression) String() string {
+	return strings.Join(e.assertions, "\n    ")
+}
+
+// Initialized the regoRenderer object. This involves initializing the template
+// objects in order to render the rego rules.
+func (r *regoRenderer) Init() error {
+	funcs := template.FuncMap{
+		"quote":  renderString,
+		"v1":     func() bool { return r.Options.RegoVersion == RegoV1 },
+		"assign": func() string { return r.assignmentOperator() },
+		"equals": func() string { return r.comparisonOperator() },
+	}
+	tmpl, _ := template.New("Header").Funcs(funcs).Parse(policyHeaderTemplate)
+	tmpl, _ = tmpl.New("Action").Parse(actionTemplate)
+	tmpl, _ = tmpl.New("Alias").Parse(aliasTemplate)
+	tmpl, _ = tmpl.New("Default").Parse(defaultTemplate)
+	tmpl, _ = tmpl.New("Actions").Parse(actionsTemplate)
+	tmpl, _ = tmpl.New("Conversions").Parse(conversionsTemplate)
+
+	r.Tmpl = tmpl
+	return nil
+}
+
+// assignmentOperator returns the operator that assigns values in the rego
+// version being generated.
+func (r regoRenderer) assignmentOperator() string {
+	if r.Options.RegoVersion == RegoV1 {
+		return ":="
+	}
+	return "="
+}
+
+// comparisonOperator returns the operator that compares values in the rego
+// version being generated.
+func (r regoRenderer) comparisonOperator() string {
+	if r.Options.RegoVersion == RegoV1 {
+		return "=="
+	}
+	return "="
+}
+
+// renderEquality renders the comparison of the two given values.
+func (r regoRenderer) renderEquality(left, right string) string {
+	return left + " " + r.comparisonOperator() + " " + right
+}
+
+// renders the named rego segment related to the templateName: Header, Action,
+// Alias, Default, Actions or Conversions.
+func (r regoRenderer) renderTemplate(templateName string, outputStruct interface{}) string {
+	var render bytes.Buffer
+
+	err := r.Tmpl.ExecuteTemplate(&render, templateName, outputStruct)
+
+	if err != nil {
+		return ""
+	}
+
+	return render.String()
+}
+
+func (r regoRenderer) renderRuleEntry(rule regoRule) string {
+	return r.renderTemplate(rule.RuleType, rule)
+}
+
+func (r regoRenderer) String() string {
+	var outputPolicies []string
+	for _, rule := range r.Rules {
+		outputPolicies = append(outputPolicies, r.renderRuleEntry(rule))
+	}
+	if r.usesConversions {
+		outputPolicies = append(outputPolicies, r.renderTemplate("Conversions", r))
+	}
+	outputPolicies = append(outputPolicies, r.renderTemplate("Actions", r))
+	return r.renderTemplate("Header", r) + strings.Join(outputPolicies, "\n") + "\n"
+}
+
+// renderPolicy converts the rules of the given policy into rego rules and
+// persists them on to the Rules entry of the regoRenderer object.
+func (r *regoRenderer) renderPolicy(policy *Policy) error {
+	var rulesList []regoRule
+
+	policyRules := policy.Rules
+	if r.Options.SortRules {
+		policyRules = append([]Rule(nil), policy.Rules...)
+		sort.SliceStable(policyRules, func(i, j int) bool {
+			return policyRules[i].Name < policyRules[j].Name
+		})
+	}
+
+	var ruleNames []string
+	for _, policyRule := range policy.Rules {
+		ruleNames = append(ruleNames, policyRule.Name)
+	}
+	r.ruleNames = regoRuleNames(ruleNames)
+
+	for _, key := range policy.duplicateKeys {
+		r.key = key
+		r.warn(WarningDuplicateKey, "The key is defined more than once, only its last value is used")
+	}
+
+	var errs PolicyErrors
+	r.cycleReferences = make(map[[2]string]bool)
+	for _, referenceError := range CheckReferences(policy) {
+		// The rule that has the reference is the one before last in the
+		// chain.
+		r.key = referenceError.Chain[len(referenceError.Chain)-2]
+		reference := referenceError.Chain[len(referenceError.Chain)-1]
+		if referenceError.Kind == ReferenceCycle {
+			// Rego doesn't allow recursion, so the reference that closes
+			// the cycle never holds.
+			r.cycleReferences[[2]string{r.key, reference}] = true
+		}
+		if !r.Options.StrictReferences {
+			if _, ok := r.ruleNames["default"]; ok && referenceError.Kind == ReferenceMissing {
+				r.warn(WarningInvalidReference, "%v, so the default rule is checked instead", referenceError)
+			} else {
+				r.warn(WarningInvalidReference, "%v, so the reference never holds", referenceError)
+			}
+			continue
+		}
+		errs = append(errs, &PolicyError{
+			Kind:    ErrorInvalidReference,
+			Key:     r.key,
+			Message: referenceError.Error(),
+			Err:     referenceError,
+		})
+	}
+
+	for _, policyRule := range policyRules {
+		// Every rule, actions included, gets a predicate that other rules
+		// can refer to.
+		rule := regoRule{RuleType: "Alias", Name: r.ruleName(policyRule.Name)}
+		if rule.Name != policyRule.Name {
+			// Keep the original name around so it can be traced back
+			rule.Comment = "oslo.policy rule " + renderString(policyRule.Name)
+		}
+		r.key = policyRule.Name
+		rules, err := r.renderCheck(rule, negationNormalForm(policyRule.Check))
+		if err != nil {
+			keyErrors := inKey(policyRule.Name, err)
+			errs = append(errs, inExpression(policy.expressions[policyRule.Name], keyErrors)...)
+			// Keep going to report the errors of the other rules, with
+			// this one never holding.
+			rules, _ = r.renderCheck(rule, FalseCheck{})
+		}
+		rulesList = append(rulesList, rules...)
+
+		if policyRule.IsAction() {
+			action := regoRule{RuleType: "Action", Name: policyRule.Name}
+			action.Expression.assertions = []string{rule.Name}
+			rulesList = append(rulesList, action)
+			r.Actions = append(r.Actions, policyRule.Name)
+		}
+	}
+
+	for _, policyRule := range policy.Rules {
+		if policyRule.Name == "default" {
+			fallback := regoRule{RuleType: "Default"}
+			fallback.Expression.assertions = []string{r.ruleName(policyRule.Name)}
+			rulesList = append(rulesList, fallback)
+		}
+	}
+
+	r.Rules = rulesList
+	return errorOrNil(errs)
+}
+
+// renderCheck renders the given check as the body of baseRule. Rego
+// expresses "or" by defining the same rule several times, so every
+// alternative of the check results in an entry of its own. The sub-rules
+// needed to express the check are returned after them.
+func (r *regoRenderer) renderCheck(baseRule regoRule, check Check) ([]regoRule, error) {
+	var outputRules []regoRule
+	var subRules []regoRule
+
+	alternatives := disjuncts(check)
+	if len(alternatives) == 0 {
+		// Like in oslo.policy, an empty "or" never holds.
+		alternatives = []Check{FalseCheck{}}
+	}
+	for index, alternative := range alternatives {
+		rule := newRule(baseRule)
+		if index == 0 {
+			rule.Comment = baseRule.Comment
+		}
+		assertions, alternativeSubRules, err := r.renderAssertions(baseRule.Name, alternative)
+		if err != nil {
+			return nil, err
+		}
+		rule.Expression.assertions = assertions
+		outputRules = append(outputRules, rule)
+		subRules = append(subRules, alternativeSubRules...)
+	}
+	return append(outputRules, subRules...), nil
+}
+
+// renderAssertions renders the given check as a list of assertions that all
+// need to hold. The check has to be in negation normal form. Checks that can't
+// be expressed as plain assertions, such as a nested "or", are rendered as
+// sub-rules which are returned as the second value. Their names are derived
+// from the owner, which is the name of the rule being rendered.
+func (r *regoRenderer) renderAssertions(owner string, check Check) ([]string, []regoRule, error) {
+	r.warnQuotedWhitespace(check)
+	switch typedCheck := check.(type) {
+	case AndCheck:
+		if len(typedCheck.Checks) == 0 {
+			// Like in oslo.policy, an empty "and" always holds.
+			return []string{"true"}, nil, nil
+		}
+		var assertions []string
+		var subRules []regoRule
+		for _, subCheck := range typedCheck.Checks {
+			subAssertions, subCheckRules, err := r.renderAssertions(owner, subCheck)
+			if err != nil {
+				return nil, nil, err
+			}
+			assertions = append(assertions, subAssertions...)
+			subRules = append(subRules, subCheckRules...)
+		}
+		return assertions, subRules, nil
+	case OrCheck:
+		subRule, subRules, err := r.renderSubRule(owner, typedCheck)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{subRule.Name}, subRules, nil
+	case NotCheck:
+		// The checks are in negation normal form, so only single checks
+		// are negated.
+		r.warnNegatedCheck(typedCheck)
+		assertions, subRules, err := r.renderAssertions(owner, typedCheck.Check)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"not " + assertions[0]}, subRules, nil
+	case RoleCheck:
+		assertion, err := r.renderRoleCheck(typedCheck)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{assertion}, nil, nil
+	case RuleCheck:
+		name := typedCheck.Match
+		if _, ok := r.ruleNames[name]; !ok {
+			// Like in oslo.policy, a reference to an undefined rule checks
+			// the default rule instead, and never holds if there's none.
+			if _, ok := r.ruleNames["default"]; !ok {
+				return []string{"false"}, nil, nil
+			}
+			name = "default"
+		}
+		if r.cycleReferences[[2]string{r.key, name}] {
+			return []string{"false"}, nil, nil
+		}
+		return []string{r.ruleName(name)}, nil, nil
+	case GenericCheck:
+		assertion, err := r.renderGenericCheck(typedCheck)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{assertion}, nil, nil
+	case HTTPCheck:
+		assertion, err := r.renderHTTPCheck(typedCheck)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{assertion}, nil, nil
+	case TrueCheck:
+		return []string{"true"}, nil, nil
+	case FalseCheck:
+		return []string{"false"}, nil, nil
+	}
+	errorMessage := fmt.Sprintf("Unknown check type %T", check)
+	return nil, nil, &PolicyError{Kind: ErrorInvalidValue, Message: errorMessage}
+}
+
+// ruleName returns the name that the given rule of the policy gets in rego.
+func (r regoRenderer) ruleName(name string) string {
+	return r.ruleNames[name]
+}
+
+// disjuncts returns the alternatives of the given check. Nested "or" checks
+// are flattened, anything else is a single alternative.
+func disjuncts(check Check) []Check {
+	orCheck, ok := check.(OrCheck)
+	if !ok {
+		return []Check{check}
+	}
+	var alternatives []Check
+	for _, subCheck := range orCheck.Checks {
+		alternatives = append(alternatives, disjuncts(subCheck)...)
+	}
+	return alternatives
+}
+
+// renderSubRule renders the given check as a sub-rule of the owner. If the
+// owner already needed a sub-rule for the very same check, it is reused and
+// no rules are returned for it.
+func (r *regoRenderer) renderSubRule(owner string, check Check) (regoRule, []regoRule, error) {
+	if r.subRules == nil {
+		r.subRules = make(map[string]string)
+	}
+	content := check.String()
+	subRule := createSubRule(subRuleName(subRulePrefix, owner, content))
+	for suffix := 2; ; suffix++ {
+		renderedContent, ok := r.subRules[subRule.Name]
+		if !ok {
+			break
+		} else if renderedContent == content {
+			return subRule, nil, nil
+		}
+		// Two different checks got the same name, which should be
+		// extremely rare. Add a suffix to tell them apart.
+		subRule.Name = fmt.Sprintf("%s_%d", subRuleName(subRulePrefix, owner, content), suffix)
+	}
+	r.subRules[subRule.Name] = content
+
+	subRules, err := r.renderCheck(subRule, check)
+	if err != nil {
+		return regoRule{}, nil, err
+	}
+	return subRule, subRules, nil
+}
+
+// Returns the name for a sub-rule with the named prefix. The name is derived
+// from the name of the rule owning the sub-rule and the content of the
+// sub-rule, so it is the same on every run for the same input.
+func subRuleName(prefix, owner, content string) string {
+	hash := sha256.Sum256([]byte(owner + "\x00" + content))
+	return prefix + "_" + hex.EncodeToString(hash[:6])
+}
+
+func createSubRule(name string) regoRule {
+	subRule := regoRule{RuleType: "Alias", Name: name}
+	subRule.Expression = expression{}
+	return subRule
+}
+
+func newRule(baseRule regoRule) regoRule {
+	rule := regoRule{RuleType: baseRule.RuleType, Name: baseRule.Name}
+	rule.Expression = expression{}
+	return rule
+}
+
+// valueIsQuotedString tells if the given value is a python string literal,
+// quoted with single or double quotes.
+func valueIsQuotedString(stringValue string) bool {
+	if len(stringValue) < 2 {
+		return false
+	}
+	quote := stringValue[0]
+	if (quote == '\'' || quote == '"') && stringValue[len(stringValue)-1] == quote {
+		return true
+	}
+	return false
+}
+
+// The rego keywords, which can't be used to refer to a key with a dot.
+var regoKeywords = map[string]bool{
+	"as": true, "contains": true, "default": true, "else": true,
+	"every": true, "false": true, "if": true, "import": true, "in": true,
+	"not": true, "null": true, "package": true, "some": true, "true": true,
+	"with": true,
+}
+
+var regoIdentifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Renders a reference to the value at the given dotted path inside of root,
+// like oslo.policy walks nested dictionaries, e.g. "project.domain.id"
+// becomes root.project.domain.id. Segments that aren't valid rego identifiers
+// (or are keywords) are looked up with brackets, e.g. root["project-id"].
+func renderReference(root, path string) string {
+	reference := root
+	for _, segment := range strings.Split(path, ".") {
+		if regoIdentifierRegexp.MatchString(segment) && !regoKeywords[segment] {
+			reference += "." + segment
+		} else {
+			reference += "[" + renderString(segment) + "]"
+		}
+	}
+	return reference
+}
+
+// Renders the string that python's str() gives for the given constant, which
+// is what oslo.policy compares the match of a check with, e.g. "True" for
+// True and "16" for 0x10. If the value isn't a constant, the second output is
+// false.
+func renderConstantString(value string) (string, bool) {
+	if constant, ok := pythonConstantString(value); ok {
+		return renderString(constant), true
+	} else if valueIsQuotedString(value) {
+		return renderString(value[1 : len(value)-1]), true
+	}
+	return "", false
+}
+
+// Renders the values found at the given dotted path of the credentials. Like
+// oslo.policy, if a value along the path is a list, each of its items is
+// looked into, so a check on a list-valued credential holds if any of the
+// items matches.
+func (r *regoRenderer) renderCredentialValues(path string) string {
+	r.usesConversions = true
+	values := "credentials"
+	for _, segment := range strings.Split(path, ".") {
+		values = "openstack_values(" + renderReference(values, segment) + ")[_]"
+	}
+	return values
+}
+
+// matchIsSingleQuoted tells if the given match is quoted with single quotes,
+// which are stripped before comparing it. oslo.policy keeps them, so matches
+// quoted with double quotes are compared with their quotes.
+func matchIsSingleQuoted(match string) bool {
+	return valueIsQuotedString(match) && match[0] == '\''
+}
+
+// Renders the match of a check as a string, the way oslo.policy formats it
+// with the target.
+func (r *regoRenderer) renderMatch(match string) (string, error) {
+	if matchIsSingleQuoted(match) {
+		match = match[1 : len(match)-1]
+	}
+	return r.renderTargetInterpolation(match)
+}
+
+// renders generic checks. oslo.policy compares them as strings: the kind,
+// which is either a constant or the path of a value in the credentials, is
+// converted with str() and compared with the match formatted with the
+// target. e.g. "is_admin:True" holds for both true and "True".
+func (r *regoRenderer) renderGenericCheck(check GenericCheck) (string, error) {
+	r.warnGenericCheck(check)
+	match, err := r.renderMatch(check.Match)
+	if err != nil {
+		return "", err
+	}
+	if constant, ok := renderConstantString(check.Kind); ok {
+		return r.renderEquality(constant, match), nil
+	}
+	return r.renderEquality("openstack_str("+r.renderCredentialValues(check.Kind)+")", match), nil
+}
+
+// Renders the reference to the target value given in the python format
+// "%(name)s"
+func renderTargetReference(value string) string {
+	return renderReference("target", value[2:len(value)-2])
+}
+
+// Renders a role check. oslo.policy lowercases both the role in the policy
+// and the roles in the credentials before comparing them, so unless the
+// StrictRoleMatching option is set, we do the same. The role may also be
+// taken from the target, e.g. "role:%(target.role.name)s".
+func (r *regoRenderer) renderRoleCheck(check RoleCheck) (string, error) {
+	if strings.Contains(check.Match, "%") {
+		targetValue, err := r.renderTargetInterpolation(check.Match)
+		if err != nil {
+			return "", err
+		}
+		if r.Options.StrictRoleMatching {
+			return r.renderRoleMembership(targetValue), nil
+		}
+		return r.renderRoleMembership("lower(" + targetValue + ")"), nil
+	}
+	if r.Options.StrictRoleMatching {
+		if check.Match != strings.ToLower(check.Match) {
+			r.warn(WarningStrictRoleMatching, "The case of the role is compared, oslo.policy ignores it: %v", check)
+		}
+		return r.renderRoleMembership(renderString(check.Match)), nil
+	}
+	return r.renderRoleMembership(renderString(strings.ToLower(check.Match))), nil
+}
+
+// Renders the assertion that the credentials have the given role, which is
+// compared with the lowercased roles unless StrictRoleMatching is set.
+func (r *regoRenderer) renderRoleMembership(role string) string {
+	if r.Options.RegoVersion == RegoV1 {
+		if r.Options.StrictRoleMatching {
+			return role + " in credentials.roles"
+		}
+		return role + " in [lower(role) | some role in credentials.roles]"
+	}
+	if r.Options.StrictRoleMatching {
+		return "credentials.roles[_] = " + role
+	}
+	return "lower(credentials.roles[_]) = " + role
+}
+
+// Renders an HTTP check according to the HTTPChecks option. By default the
+// check is rendered the way oslo.policy runs it: posting the rule, target and
+// credentials as JSON encoded form fields, and holding if the response is
+// "True" (possibly quoted).
+func (r *regoRenderer) renderHTTPCheck(check HTTPCheck) (string, error) {
+	url, err := r.renderTargetInterpolation(check.URL())
+	if err != nil {
+		return "", err
+	}
+
+	switch r.Options.HTTPChecks {
+	case HTTPCheckError:
+		errorMessage := fmt.Sprintf("HTTP checks are not allowed: %v", check)
+		return "", &PolicyError{Kind: ErrorHTTPCheckNotAllowed, Expression: check.String(), Message: errorMessage}
+	case HTTPCheckData:
+		return r.renderEquality(httpChecksDataPath+"["+url+"]", "true"), nil
+	}
+
+	request := `{"method": "POST", "url": ` + url + `, ` +
+		`"headers": {"Content-Type": "application/x-www-form-urlencoded"}, ` +
+		`"raw_body": urlquery.encode_object({"rule": json.marshal(rule), ` +
+		`"target": json.marshal(target), "credentials": json.marshal(credentials)})}`
+	return r.renderEquality(`trim(http.send(`+request+`).raw_body, "\"")`, `"True"`), nil
+}
+
+// Renders a string that references values from the target with the python
+// format "%(name)s", e.g. "http://example.com/%(target.id)s", as a rego
+// string. If there are references, they are converted with openstack_str and
+// filled with sprintf, and a value that is a single reference is rendered as
+// the converted reference itself. "%%" is a literal "%", and any other format
+// specifier is an error.
+func (r *regoRenderer) renderTargetInterpolation(value string) (string, error) {
+	if targetValueIsReference(value) {
+		r.usesConversions = true
+		return "openstack_str(" + renderTargetReference(value) + ")", nil
+	}
+
+	parts, err := parseInterpolation(value)
+	if err != nil {
+		return "", err
+	}
+
+	var format strings.Builder
+	var literal strings.Builder
+	var arguments []string
+	for _, part := range parts {
+		if !part.reference {
+			format.WriteString(strings.Replace(part.text, "%", "%%", -1))
+			literal.WriteString(part.text)
+			continue
+		}
+		format.WriteString("%v")
+		r.usesConversions = true
+		arguments = append(arguments, "openstack_str("+renderReference("target", part.text)+")")
+	}
+
+	if len(arguments) == 0 {
+		return renderString(literal.String()), nil
+	}
+	return "sprintf(" + renderString(format.String()) + ", [" + strings.Join(arguments, ", ") + "])", nil
+}
+
+// validateInputPath tells if the given path of the input document can be
+// imported, which is the case if it consists of rego identifiers separated by
+// dots. e.g. "request.token"
+func validateInputPath(path string) bool {
+	for _, segment := range strings.Split(path, ".") {
+		if !regoIdentifierRegexp.MatchString(segment) || regoKeywords[segment] {
+			return false
+		}
+	}
+	return true
+}
+
+// firstRune returns the first character of the given string, or an empty
+// string if there's none.
+func firstRune(value string) string {
+	for _, r := range value {
+		return string(r)
+	}
+	return ""
+}
+
+// Renders the given value as a rego string literal. Quotes, backslashes and
+// control characters are escaped, so the value can't break out of the
+// literal whatever it contains.
+func renderString(value string) string {
+	var output strings.Builder
+	output.WriteByte('"')
+	for _, char := range value {
+		switch char {
+		case '"':
+			output.WriteString(`\"`)
+		case '\\':
+			output.WriteString(`\\`)
+		case '\n':
+			output.WriteString(`\n`)
+		case '\r':
+			output.WriteString(`\r`)
+		case '\t':
+			output.WriteString(`\t`)
+		default:
+			// Invalid UTF-8 is replaced by utf8.RuneError, which is
+			// escaped too so the output stays valid UTF-8.
+			if char < 0x20 || char == 0x7f || char == utf8.RuneError {
+				fmt.Fprintf(&output, `\u%04x`, char)
+			} else {
+				output.WriteRune(char)
+			}
+		}
+	}
+	output.WriteByte('"')
+	return output.String()
+}
+
+// RenderRego takes a parsed policy and converts it into Rego language, using
+// the given packageName for the resulting package. Every rule is rendered
+// even if some fail, and the error is a PolicyErrors with all of their
+// problems.
+func RenderRego(packageName string, policy *Policy, opts Options) (string, error) {
+	packageNameWorks := validatePackageName(packageName)
+
+	if !packageNameWorks {
+		errorMessage := fmt.Sprintf("The package name %s is invalid. "+
+			"It must consist of strings of letters and digits separated by "+
+			"dots ('.'). e.g. 'openstack.policy'", packageName)
+		return "", PolicyErrors{{Kind: ErrorInvalidOption, Message: errorMessage}}
+	}
+
+	opts.Input = opts.Input.withDefaults()
+	for _, path := range []string{opts.Input.Credentials, opts.Input.Action, opts.Input.Target} {
+		if !validateInputPath(path) {
+			errorMessage := fmt.Sprintf("The input path %s is invalid. "+
+				"It must consist of identifiers separated by dots ('.'). "+
+				"e.g. 'token' or 'request.token'", path)
+			return "", PolicyErrors{{Kind: ErrorInvalidOption, Message: errorMessage}}
+		}
+	}
+
+	renderer := regoRenderer{Package: packageName, Options: opts}
+	renderer.Init()
+	err := renderer.renderPolicy(policy)
+	if err != nil && !opts.PartialOutput {
+		return "", err
+	}
+	return renderer.String(), err
+}
@@ -0,0 +1,146 @@
+package oslopolicy2rego
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// WarningCode identifies the kind of problem a Warning reports. The codes
+// don't change between versions, so they can be used to suppress warnings.
+type WarningCode string
+
+const (
+	// WarningDuplicateKey means a key is defined more than once in the
+	// policy. Only its last value is used.
+	WarningDuplicateKey WarningCode = "duplicate-key"
+	// WarningInvalidReference means a "rule:" check refers to an undefined
+	// rule or closes a reference cycle. Like in oslo.policy, a reference to
+	// an undefined rule checks the default rule instead, or never holds if
+	// there's none. A reference that closes a cycle never holds, while
+	// oslo.policy fails on it.
+	WarningInvalidReference WarningCode = "invalid-reference"
+	// WarningCredentialKey means the kind of a check looks like a constant,
+	// e.g. "true" or "010", but isn't a python literal, so it's looked up
+	// in the credentials.
+	WarningCredentialKey WarningCode = "credential-key"
+	// WarningLiteralMatch means the match of a check is a bare word that
+	// is compared as a string, e.g. "project_id:project_id", which is
+	// often a mistyped reference to the target.
+	WarningLiteralMatch WarningCode = "literal-match"
+	// WarningQuotedMatch means the match of a check is quoted with single
+	// quotes. oslo.policy compares it with its quotes, which then never
+	// matches, while the generated policy compares it without them.
+	WarningQuotedMatch WarningCode = "quoted-match"
+	// WarningNegatedLookup means a "not" is applied to a check that looks
+	// up values, so it also holds when the values are missing or can't be
+	// compared, where oslo.policy may fail instead.
+	WarningNegatedLookup WarningCode = "negated-lookup"
+	// WarningFloatMatch means a value from the credentials is compared with
+	// a float. Rego formats floats differently than python, e.g. 1.0 is
+	// "1" instead of "1.0".
+	WarningFloatMatch WarningCode = "float-match"
+	// WarningStrictRoleMatching means a role is compared case-sensitively
+	// because the StrictRoleMatching option is set, unlike in oslo.policy.
+	WarningStrictRoleMatching WarningCode = "strict-role-matching"
+	// WarningQuotedWhitespace means a check has a quoted literal with
+	// whitespace. oslo.policy splits the literal at the whitespace and fails
+	// to parse the rule, which then never holds.
+	WarningQuotedWhitespace WarningCode = "quoted-whitespace"
+	// WarningQuotedColon means the quoted kind of a check has a colon.
+	// oslo.policy splits the check at that colon instead of the one after
+	// the kind.
+	WarningQuotedColon WarningCode = "quoted-colon"
+)
+
+// WarningCodes returns the codes of all the warnings that can be reported.
+func WarningCodes() []WarningCode {
+	return []WarningCode{
+		WarningDuplicateKey, WarningInvalidReference, WarningCredentialKey, WarningLiteralMatch,
+		WarningQuotedMatch, WarningNegatedLookup, WarningFloatMatch, WarningStrictRoleMatching,
+		WarningQuotedWhitespace, WarningQuotedColon,
+	}
+}
+
+// Warning reports a part of the policy that is converted, but whose
+// generated rego may behave differently than oslo.policy.
+type Warning struct {
+	Code WarningCode
+	// Key is the key of the rule the warning is about, if any.
+	Key string
+	// Message describes the problem.
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Key != "" {
+		return fmt.Sprintf("Warning %s in key %s: \"%s\"", w.Code, w.Key, w.Message)
+	}
+	return fmt.Sprintf("Warning %s: \"%s\"", w.Code, w.Message)
+}
+
+// Kinds that look like constants without being python literals, e.g. "true"
+// or "1_", which oslo.policy looks up in the credentials.
+var constantLikeRegexp = regexp.MustCompile(`^([-+.]?[0-9]|(?i:true|false|none|null)$)`)
+
+// warn reports a warning about the rule being rendered, unless its code is
+// suppressed.
+func (r *regoRenderer) warn(code WarningCode, format string, args ...interface{}) {
+	if r.Options.Warnings == nil {
+		return
+	}
+	for _, suppressed := range r.Options.SuppressWarnings {
+		if code == suppressed {
+			return
+		}
+	}
+	r.Options.Warnings(Warning{Code: code, Key: r.key, Message: fmt.Sprintf(format, args...)})
+}
+
+// warnGenericCheck reports the parts of a generic check that may be compared
+// differently than in oslo.policy.
+func (r *regoRenderer) warnGenericCheck(check GenericCheck) {
+	if valueIsQuotedString(check.Kind) && strings.Contains(check.Kind, ":") {
+		r.warn(WarningQuotedColon, "oslo.policy splits the check at the first colon, inside of the kind: %v",
+			check)
+	}
+
+	_, kindIsConstant := renderConstantString(check.Kind)
+	if !kindIsConstant && constantLikeRegexp.MatchString(check.Kind) {
+		r.warn(WarningCredentialKey, "%s isn't a python literal, so it's looked up in the credentials: %v",
+			check.Kind, check)
+	}
+
+	if matchIsSingleQuoted(check.Match) {
+		r.warn(WarningQuotedMatch, "The match is compared without its quotes, oslo.policy keeps them: %v", check)
+	} else if _, ok := pythonConstantString(check.Match); !ok && !valueIsQuotedString(check.Match) &&
+		!strings.Contains(check.Match, "%") {
+		r.warn(WarningLiteralMatch, "%s is compared as a string, it isn't a reference to the target "+
+			"like %%(%s)s: %v", check.Match, check.Match, check)
+	} else if !kindIsConstant && pythonFloatRegexp.MatchString(check.Match) {
+		r.warn(WarningFloatMatch, "Rego formats floats differently than python: %v", check)
+	}
+}
+
+// warnNegatedCheck reports the negated checks that look up values, which
+// hold if the values are missing.
+func (r *regoRenderer) warnNegatedCheck(check NotCheck) {
+	switch typedCheck := check.Check.(type) {
+	case GenericCheck, RoleCheck:
+		r.warn(WarningNegatedLookup, "The check also holds if the values it looks up are missing "+
+			"or can't be compared: %v", typedCheck)
+	}
+}
+
+// warnQuotedWhitespace reports the single checks with whitespace, which only
+// quoted literals can have, and that oslo.policy can't parse.
+func (r *regoRenderer) warnQuotedWhitespace(check Check) {
+	switch check.(type) {
+	case RoleCheck, RuleCheck, GenericCheck, HTTPCheck:
+		if strings.IndexFunc(check.String(), unicode.IsSpace) != -1 {
+			r.warn(WarningQuotedWhitespace, "oslo.policy splits the check at its whitespace and fails to "+
+				"parse the rule, so it never holds: %v", check)
+		}
+	}
+}
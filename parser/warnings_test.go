@@ -0,0 +1,117 @@
+package oslopolicy2rego
+
+import (
+	"reflect"
+	"testing"
+)
+
+// convertWithWarnings converts the given policy and returns the warnings that
+// were reported.
+func convertWithWarnings(t *testing.T, input string, opts Options) []Warning {
+	var warnings []Warning
+	opts.Warnings = func(warning Warning) {
+		warnings = append(warnings, warning)
+	}
+	if _, err := OsloPolicy2RegoWithOptions("openstack.policy", input, opts); err != nil {
+		t.Fatalf("OsloPolicy2RegoWithOptions() failed: %v", err)
+	}
+	return warnings
+}
+
+func warningCodes(warnings []Warning) []WarningCode {
+	var codes []WarningCode
+	for _, warning := range warnings {
+		codes = append(codes, warning.Code)
+	}
+	return codes
+}
+
+func TestOsloPolicy2RegoWarnings(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		opts        Options
+		want        []WarningCode
+	}{
+		{"Nothing suspicious", `{"a": "role:admin or project_id:%(project_id)s or is_admin:True"}`,
+			Options{}, nil},
+		{"Duplicate key", "a: \"@\"\na: \"!\"", Options{}, []WarningCode{WarningDuplicateKey}},
+		{"Undefined reference", `{"a": "rule:b"}`, Options{}, []WarningCode{WarningInvalidReference}},
+		{"Lowercase boolean kind", `{"a": "true:%(enabled)s"}`, Options{},
+			[]WarningCode{WarningCredentialKey}},
+		{"Number-like kind", `{"a": "1_:%(count)s"}`, Options{}, []WarningCode{WarningCredentialKey}},
+		{"Literal match", `{"a": "project_id:project_id"}`, Options{}, []WarningCode{WarningLiteralMatch}},
+		{"Quoted match", `{"a": "project_id:'abc'"}`, Options{}, []WarningCode{WarningQuotedMatch}},
+		{"Negated lookup", `{"a": "not is_admin:True"}`, Options{}, []WarningCode{WarningNegatedLookup}},
+		{"Negated role", `{"a": "not (role:admin or rule:b)", "b": "@"}`, Options{},
+			[]WarningCode{WarningNegatedLookup}},
+		{"Float match", `{"a": "ratio:1.0"}`, Options{}, []WarningCode{WarningFloatMatch}},
+		{"Float constant", `{"a": "1.0:%(ratio)s"}`, Options{}, nil},
+		{"Strict role matching", `{"a": "role:Admin or role:member"}`, Options{StrictRoleMatching: true},
+			[]WarningCode{WarningStrictRoleMatching}},
+		{"Whitespace in a quoted kind", `{"a": "'my role':%(name)s"}`, Options{},
+			[]WarningCode{WarningQuotedWhitespace}},
+		{"Whitespace in a quoted match", `{"a": "role:\"a b\" or rule:c", "c": "@"}`, Options{},
+			[]WarningCode{WarningQuotedWhitespace}},
+		{"Quotes that pair across checks", `{"a": "role:'admin or name:'s-team"}`, Options{},
+			[]WarningCode{WarningQuotedWhitespace}},
+		{"Colon in a quoted kind", `{"a": "'a:b':%(name)s"}`, Options{}, []WarningCode{WarningQuotedColon}},
+		{"Suppressed", `{"a": "not project_id:abc"}`,
+			Options{SuppressWarnings: []WarningCode{WarningNegatedLookup}},
+			[]WarningCode{WarningLiteralMatch}},
+	}
+	for _, c := range cases {
+		got := warningCodes(convertWithWarnings(t, c.input, c.opts))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("OsloPolicy2RegoWithOptions() test case \"%s\" warned %v instead of %v",
+				c.description, got, c.want)
+		}
+	}
+}
+
+func TestOsloPolicy2RegoWarningsReferToTheirKey(t *testing.T) {
+	input := `{"a": "rule:missing", "b": "role:admin", "c": "not tenant:demo"}`
+	want := []Warning{
+		{Code: WarningInvalidReference, Key: "a",
+			Message: "Reference to the undefined rule missing: a -> missing, so the reference never holds"},
+		{Code: WarningNegatedLookup, Key: "c",
+			Message: "The check also holds if the values it looks up are missing or can't be compared: tenant:demo"},
+		{Code: WarningLiteralMatch, Key: "c",
+			Message: "demo is compared as a string, it isn't a reference to the target like %(demo)s: tenant:demo"},
+	}
+	got := convertWithWarnings(t, input, Options{})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OsloPolicy2RegoWithOptions() warned %v instead of %v", got, want)
+	}
+}
+
+func TestOsloPolicy2RegoStrictReferencesDontWarn(t *testing.T) {
+	var warnings []Warning
+	opts := Options{StrictReferences: true, Warnings: func(warning Warning) {
+		warnings = append(warnings, warning)
+	}}
+	if _, err := OsloPolicy2RegoWithOptions("openstack.policy", `{"a": "rule:b"}`, opts); err == nil {
+		t.Errorf("OsloPolicy2RegoWithOptions() didn't fail with an undefined reference")
+	}
+	if len(warnings) != 0 {
+		t.Errorf("OsloPolicy2RegoWithOptions() warned %v along with the error", warnings)
+	}
+}
+
+func TestWarningString(t *testing.T) {
+	warning := Warning{Code: WarningDuplicateKey, Key: "a", Message: "Defined twice"}
+	want := "Warning duplicate-key in key a: \"Defined twice\""
+	if got := warning.String(); got != want {
+		t.Errorf("Warning.String() returned %q instead of %q", got, want)
+	}
+}
+
+func TestWarningCodesAreUnique(t *testing.T) {
+	seen := make(map[WarningCode]bool)
+	for _, code := range WarningCodes() {
+		if seen[code] {
+			t.Errorf("WarningCodes() returned %s more than once", code)
+		}
+		seen[code] = true
+	}
+}